@@ -0,0 +1,319 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+const (
+	BulkAppsPath       = "/internal/bulk/apps"
+	BulkTaskStatesPath = "/internal/v3/bulk/task_states"
+
+	DefaultBatchSize    = 500
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 500 * time.Millisecond
+)
+
+var (
+	ErrNoMorePages      = errors.New("no more pages")
+	ErrListingRestarted = errors.New("listing restarted while it was being walked")
+)
+
+// UnexpectedStatusError is returned when the CC answers a page request
+// with a non-200 status.
+type UnexpectedStatusError struct {
+	StatusCode int
+}
+
+func (e UnexpectedStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.StatusCode)
+}
+
+// Transient reports whether the request is worth retrying.
+func (e UnexpectedStatusError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// Page is a page of a bulk listing. The listing ends with the page whose
+// NextToken is nil or JSON null.
+type Page interface {
+	NextToken() *json.RawMessage
+}
+
+type ClientOption func(*Client)
+
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTLSConfig configures the transport's TLS, including the client
+// certificates used for mutual TLS.
+func WithTLSConfig(tlsConfig *tls.Config) ClientOption {
+	return func(c *Client) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		c.httpClient = &http.Client{Transport: transport, Timeout: c.httpClient.Timeout}
+	}
+}
+
+func WithBasicAuth(username, password string) ClientOption {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+func WithBatchSize(batchSize int) ClientOption {
+	return func(c *Client) {
+		c.batchSize = batchSize
+	}
+}
+
+// WithRetries retries transient failures up to maxRetries times, waiting
+// backoff before the first retry and doubling the wait before each
+// following one.
+func WithRetries(maxRetries int, backoff time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// Client walks the CC's bulk listings.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	username   string
+	password   string
+	batchSize  int
+	maxRetries int
+	backoff    time.Duration
+}
+
+func NewClient(baseURL string, options ...ClientOption) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		batchSize:  DefaultBatchSize,
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultRetryBackoff,
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	return c, nil
+}
+
+// firstPageToken is sent with the first page request. The CC reads the
+// token parameter on every request, so it must be present from the start.
+const firstPageToken = "{}"
+
+// Iterate walks the listing at path. query is added to every page request
+// alongside batch_size and token. newPage returns a pointer for each page
+// to be decoded into.
+func (c *Client) Iterate(path string, query url.Values, newPage func() Page) *Iterator {
+	token := json.RawMessage(firstPageToken)
+	return &Iterator{
+		client:  c,
+		path:    path,
+		query:   query,
+		newPage: newPage,
+		token:   &token,
+	}
+}
+
+func (c *Client) DesiredStateFingerprints(ctx context.Context, handle func(cc_messages.CCDesiredStateFingerprintResponse) error) error {
+	it := c.Iterate(BulkAppsPath, url.Values{"format": {"fingerprint"}}, func() Page {
+		return &cc_messages.CCDesiredStateFingerprintResponse{}
+	})
+	return it.Each(ctx, func(page Page) error {
+		return handle(*page.(*cc_messages.CCDesiredStateFingerprintResponse))
+	})
+}
+
+func (c *Client) DesiredApps(ctx context.Context, handle func(cc_messages.CCDesiredStateServerResponse) error) error {
+	it := c.Iterate(BulkAppsPath, nil, func() Page {
+		return &cc_messages.CCDesiredStateServerResponse{}
+	})
+	return it.Each(ctx, func(page Page) error {
+		return handle(*page.(*cc_messages.CCDesiredStateServerResponse))
+	})
+}
+
+func (c *Client) TaskStates(ctx context.Context, handle func(cc_messages.CCTaskStatesResponse) error) error {
+	it := c.Iterate(BulkTaskStatesPath, nil, func() Page {
+		return &cc_messages.CCTaskStatesResponse{}
+	})
+	return it.Each(ctx, func(page Page) error {
+		return handle(*page.(*cc_messages.CCTaskStatesResponse))
+	})
+}
+
+// Iterator fetches the pages of a bulk listing in order.
+type Iterator struct {
+	client  *Client
+	path    string
+	query   url.Values
+	newPage func() Page
+
+	token    *json.RawMessage
+	snapshot *cc_messages.CCBulkToken
+	done     bool
+}
+
+// Next fetches the next page, or returns ErrNoMorePages once the page with
+// a null token was returned. A failed page can be retried by calling Next
+// again.
+//
+// Next returns ErrListingRestarted when a page's token carries a different
+// snapshot than the tokens before it; the pages walked so far are then
+// inconsistent with the rest of the listing.
+func (it *Iterator) Next(ctx context.Context) (Page, error) {
+	if it.done {
+		return nil, ErrNoMorePages
+	}
+
+	page := it.newPage()
+	err := it.client.fetch(ctx, it.pageURL(), page)
+	if err != nil {
+		return nil, err
+	}
+
+	token := page.NextToken()
+
+	// Tokens are opaque to the iterator, so tokens that are not
+	// CCBulkTokens simply skip the snapshot check.
+	decoded, err := cc_messages.DecodeCCBulkToken(token)
+	if err == nil && decoded != nil && decoded.HasSnapshot() {
+		if it.snapshot != nil && !it.snapshot.SameListing(*decoded) {
+			it.done = true
+			return nil, ErrListingRestarted
+		}
+		it.snapshot = decoded
+	}
+
+	it.token = token
+	it.done = isNullToken(token)
+
+	return page, nil
+}
+
+// Each calls handle with every remaining page, stopping at the first error.
+func (it *Iterator) Each(ctx context.Context, handle func(Page) error) error {
+	for {
+		page, err := it.Next(ctx)
+		if err == ErrNoMorePages {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = handle(page)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (it *Iterator) pageURL() string {
+	values := url.Values{}
+	for key, value := range it.query {
+		values[key] = value
+	}
+	values.Set("batch_size", strconv.Itoa(it.client.batchSize))
+	values.Set("token", string(*it.token))
+
+	u := *it.client.baseURL
+	u.Path = strings.TrimRight(u.Path, "/") + it.path
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+func (c *Client) fetch(ctx context.Context, pageURL string, page Page) error {
+	backoff := c.backoff
+
+	for attempt := 0; ; attempt++ {
+		err := c.fetchOnce(ctx, pageURL, page)
+		if err == nil || attempt >= c.maxRetries || !isTransient(ctx, err) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) fetchOnce(ctx context.Context, pageURL string, page Page) error {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return UnexpectedStatusError{StatusCode: resp.StatusCode}
+	}
+
+	return json.Unmarshal(body, page)
+}
+
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	switch err := err.(type) {
+	case UnexpectedStatusError:
+		return err.Transient()
+	case *url.Error:
+		return true
+	default:
+		return false
+	}
+}
+
+func isNullToken(token *json.RawMessage) bool {
+	return token == nil || bytes.Equal(bytes.TrimSpace(*token), []byte("null"))
+}
@@ -0,0 +1,270 @@
+package bulk_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/bulk"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeCC serves a fingerprint listing of three pages and records the
+// requests it received. The token following page i carries snapshots[i]
+// when snapshots is set.
+type fakeCC struct {
+	sync.Mutex
+	requests  []*http.Request
+	failures  int
+	status    int
+	snapshots []time.Time
+}
+
+func (cc *fakeCC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cc.Lock()
+	cc.requests = append(cc.requests, r)
+	if cc.failures > 0 {
+		cc.failures--
+		cc.Unlock()
+		w.WriteHeader(cc.status)
+		return
+	}
+	cc.Unlock()
+
+	var token struct {
+		Id int `json:"id"`
+	}
+	err := json.Unmarshal([]byte(r.URL.Query().Get("token")), &token)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var nextToken interface{}
+	if token.Id < 2 {
+		nextToken = cc_messages.CCBulkToken{Id: token.Id + 1}
+		if cc.snapshots != nil {
+			nextToken = cc_messages.NewCCBulkToken(token.Id+1, cc.snapshots[token.Id])
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"fingerprints": []cc_messages.CCDesiredAppFingerprint{
+			{ProcessGuid: fmt.Sprintf("process-guid-%d", token.Id), ETag: "etag"},
+		},
+		"task_states": []cc_messages.CCTaskState{
+			{TaskGuid: fmt.Sprintf("task-guid-%d", token.Id), State: cc_messages.TaskStateRunning},
+		},
+		"token": nextToken,
+	})
+}
+
+func (cc *fakeCC) Requests() []*http.Request {
+	cc.Lock()
+	defer cc.Unlock()
+	return cc.requests
+}
+
+var _ = Describe("Client", func() {
+	var (
+		cc     *fakeCC
+		server *httptest.Server
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		cc = &fakeCC{}
+		server = httptest.NewServer(cc)
+		ctx = context.Background()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newClient := func(options ...bulk.ClientOption) *bulk.Client {
+		client, err := bulk.NewClient(server.URL, options...)
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	It("walks every page until the token is null", func() {
+		var processGuids []string
+		err := newClient(bulk.WithBatchSize(10)).DesiredStateFingerprints(ctx, func(page cc_messages.CCDesiredStateFingerprintResponse) error {
+			for _, fingerprint := range page.Fingerprints {
+				processGuids = append(processGuids, fingerprint.ProcessGuid)
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(processGuids).To(Equal([]string{"process-guid-0", "process-guid-1", "process-guid-2"}))
+
+		requests := cc.Requests()
+		Expect(requests).To(HaveLen(3))
+		Expect(requests[0].URL.Path).To(Equal(bulk.BulkAppsPath))
+		Expect(requests[0].URL.Query()).To(Equal(url.Values{"batch_size": {"10"}, "format": {"fingerprint"}, "token": {"{}"}}))
+		Expect(requests[2].URL.Query().Get("token")).To(MatchJSON(`{"id":2}`))
+	})
+
+	It("walks the task states listing", func() {
+		var taskGuids []string
+		err := newClient().TaskStates(ctx, func(page cc_messages.CCTaskStatesResponse) error {
+			for _, state := range page.TaskStates {
+				taskGuids = append(taskGuids, state.TaskGuid)
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskGuids).To(HaveLen(3))
+		Expect(cc.Requests()[0].URL.Path).To(Equal(bulk.BulkTaskStatesPath))
+	})
+
+	It("returns ErrNoMorePages after the last page", func() {
+		it := newClient().Iterate(bulk.BulkAppsPath, nil, func() bulk.Page {
+			return &cc_messages.CCDesiredStateFingerprintResponse{}
+		})
+
+		for i := 0; i < 3; i++ {
+			_, err := it.Next(ctx)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		_, err := it.Next(ctx)
+		Expect(err).To(Equal(bulk.ErrNoMorePages))
+		Expect(cc.Requests()).To(HaveLen(3))
+	})
+
+	It("accepts tokens that keep their snapshot", func() {
+		snapshot := time.Unix(100, 0)
+		cc.snapshots = []time.Time{snapshot, snapshot}
+
+		var pages int
+		err := newClient().DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+			pages++
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pages).To(Equal(3))
+		Expect(cc.Requests()[1].URL.Query().Get("token")).To(MatchJSON(`{"id":1,"version":1,"snapshot":100000000000}`))
+	})
+
+	It("detects that the CC restarted the listing", func() {
+		cc.snapshots = []time.Time{time.Unix(100, 0), time.Unix(200, 0)}
+
+		var pages int
+		err := newClient().DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+			pages++
+			return nil
+		})
+		Expect(err).To(Equal(bulk.ErrListingRestarted))
+		Expect(pages).To(Equal(1))
+	})
+
+	It("stops at the first error from the handler", func() {
+		handlerErr := fmt.Errorf("boom")
+		err := newClient().DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+			return handlerErr
+		})
+		Expect(err).To(Equal(handlerErr))
+		Expect(cc.Requests()).To(HaveLen(1))
+	})
+
+	It("sends basic auth credentials", func() {
+		err := newClient(bulk.WithBasicAuth("user", "password")).DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		username, password, ok := cc.Requests()[0].BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("user"))
+		Expect(password).To(Equal("password"))
+	})
+
+	Context("when the CC fails transiently", func() {
+		BeforeEach(func() {
+			cc.failures = 2
+			cc.status = http.StatusServiceUnavailable
+		})
+
+		It("retries with backoff", func() {
+			var pages int
+			err := newClient(bulk.WithRetries(2, time.Millisecond)).DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+				pages++
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pages).To(Equal(3))
+			Expect(cc.Requests()).To(HaveLen(5))
+		})
+
+		It("gives up after the configured retries", func() {
+			err := newClient(bulk.WithRetries(1, time.Millisecond)).DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+				return nil
+			})
+			Expect(err).To(Equal(bulk.UnexpectedStatusError{StatusCode: http.StatusServiceUnavailable}))
+			Expect(cc.Requests()).To(HaveLen(2))
+		})
+
+		It("stops retrying when the context is cancelled", func() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+
+			err := newClient(bulk.WithRetries(2, time.Hour)).DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+				return nil
+			})
+			Expect(err).To(Equal(context.DeadlineExceeded))
+			Expect(cc.Requests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when the CC rejects the request", func() {
+		BeforeEach(func() {
+			cc.failures = 1
+			cc.status = http.StatusUnauthorized
+		})
+
+		It("does not retry", func() {
+			err := newClient(bulk.WithRetries(3, time.Millisecond)).DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+				return nil
+			})
+			Expect(err).To(Equal(bulk.UnexpectedStatusError{StatusCode: http.StatusUnauthorized}))
+			Expect(cc.Requests()).To(HaveLen(1))
+		})
+	})
+
+	Context("over TLS", func() {
+		BeforeEach(func() {
+			server.Close()
+			server = httptest.NewTLSServer(cc)
+		})
+
+		It("uses the given TLS config", func() {
+			rootCAs := x509.NewCertPool()
+			rootCAs.AddCert(server.Certificate())
+
+			err := newClient(bulk.WithTLSConfig(&tls.Config{RootCAs: rootCAs})).DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("fails without a trusted certificate", func() {
+			err := newClient(bulk.WithRetries(0, 0)).DesiredStateFingerprints(ctx, func(cc_messages.CCDesiredStateFingerprintResponse) error {
+				return nil
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,144 @@
+package bulk
+
+import (
+	"errors"
+	"sort"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+var ErrReconcilerFinished = errors.New("reconciler already finished")
+
+// DesiredStateDiff is the work needed to bring the DesiredLRPs in the
+// AppLRPDomain in line with the CC.
+type DesiredStateDiff struct {
+	// Create holds apps the CC desires that have no DesiredLRP.
+	Create []cc_messages.CCDesiredAppFingerprint
+
+	// Update holds apps whose DesiredLRP annotation differs from the CC's
+	// ETag.
+	Update []cc_messages.CCDesiredAppFingerprint
+
+	// Delete holds the process guids of DesiredLRPs the CC no longer
+	// desires. It is only filled once a complete listing was reconciled.
+	Delete []string
+
+	// Unconfirmed holds the process guids of DesiredLRPs that had not been
+	// seen when an incomplete listing was finished. Their desired state is
+	// unknown: they must neither be deleted nor counted as fresh.
+	Unconfirmed []string
+}
+
+func (d DesiredStateDiff) Empty() bool {
+	return len(d.Create) == 0 && len(d.Update) == 0 && len(d.Delete) == 0 && len(d.Unconfirmed) == 0
+}
+
+// DesiredStateReconciler diffs the CC's desired app fingerprints against the
+// DesiredLRPs in the AppLRPDomain one page at a time.
+//
+// Only the process guid and annotation of each DesiredLRP are retained, along
+// with the process guid of every app reported in Create so far, which keeps
+// a guid repeated across pages from being created twice. Memory therefore
+// grows with the number of DesiredLRPs plus the number of apps created, not
+// with the size of their desired state, until Finish releases it.
+type DesiredStateReconciler struct {
+	existing map[string]*existingLRP
+	created  map[string]struct{}
+	finished bool
+}
+
+type existingLRP struct {
+	etag string
+	seen bool
+}
+
+// NewDesiredStateReconciler starts a reconciliation against schedulingInfos.
+// Scheduling infos outside the AppLRPDomain are ignored.
+func NewDesiredStateReconciler(schedulingInfos []*models.DesiredLRPSchedulingInfo) *DesiredStateReconciler {
+	existing := make(map[string]*existingLRP, len(schedulingInfos))
+	for _, schedulingInfo := range schedulingInfos {
+		if schedulingInfo.Domain != cc_messages.AppLRPDomain {
+			continue
+		}
+		existing[schedulingInfo.ProcessGuid] = &existingLRP{etag: schedulingInfo.Annotation}
+	}
+
+	return &DesiredStateReconciler{
+		existing: existing,
+		created:  map[string]struct{}{},
+	}
+}
+
+// AddPage reconciles a page of the fingerprint listing and returns the
+// creates and updates it implies. A process guid repeated across pages is
+// only reported the first time.
+func (r *DesiredStateReconciler) AddPage(page cc_messages.CCDesiredStateFingerprintResponse) (DesiredStateDiff, error) {
+	return r.Add(page.Fingerprints...)
+}
+
+func (r *DesiredStateReconciler) Add(fingerprints ...cc_messages.CCDesiredAppFingerprint) (DesiredStateDiff, error) {
+	if r.finished {
+		return DesiredStateDiff{}, ErrReconcilerFinished
+	}
+
+	var diff DesiredStateDiff
+	for _, fingerprint := range fingerprints {
+		lrp, ok := r.existing[fingerprint.ProcessGuid]
+		if !ok {
+			if _, created := r.created[fingerprint.ProcessGuid]; !created {
+				r.created[fingerprint.ProcessGuid] = struct{}{}
+				diff.Create = append(diff.Create, fingerprint)
+			}
+			continue
+		}
+
+		if lrp.seen {
+			continue
+		}
+		lrp.seen = true
+
+		if lrp.etag != fingerprint.ETag {
+			diff.Update = append(diff.Update, fingerprint)
+		}
+	}
+
+	return diff, nil
+}
+
+// Finish ends the reconciliation. When complete is true every page of the
+// listing was added and the DesiredLRPs that were not seen are reported in
+// Delete; otherwise they are reported in Unconfirmed.
+func (r *DesiredStateReconciler) Finish(complete bool) (DesiredStateDiff, error) {
+	if r.finished {
+		return DesiredStateDiff{}, ErrReconcilerFinished
+	}
+	r.finished = true
+
+	var unseen []string
+	for processGuid, lrp := range r.existing {
+		if !lrp.seen {
+			unseen = append(unseen, processGuid)
+		}
+	}
+	sort.Strings(unseen)
+
+	r.existing = nil
+	r.created = nil
+
+	if complete {
+		return DesiredStateDiff{Delete: unseen}, nil
+	}
+	return DesiredStateDiff{Unconfirmed: unseen}, nil
+}
+
+// ReconcileDesiredState reconciles a complete listing held in memory.
+func ReconcileDesiredState(schedulingInfos []*models.DesiredLRPSchedulingInfo, fingerprints []cc_messages.CCDesiredAppFingerprint) DesiredStateDiff {
+	reconciler := NewDesiredStateReconciler(schedulingInfos)
+
+	diff, _ := reconciler.Add(fingerprints...)
+	finished, _ := reconciler.Finish(true)
+	diff.Delete = finished.Delete
+
+	return diff
+}
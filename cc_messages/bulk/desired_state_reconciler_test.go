@@ -0,0 +1,157 @@
+package bulk_test
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/bulk"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DesiredStateReconciler", func() {
+	var (
+		schedulingInfos []*models.DesiredLRPSchedulingInfo
+		reconciler      *bulk.DesiredStateReconciler
+	)
+
+	schedulingInfo := func(processGuid, domain, annotation string) *models.DesiredLRPSchedulingInfo {
+		return &models.DesiredLRPSchedulingInfo{
+			DesiredLRPKey: models.NewDesiredLRPKey(processGuid, domain, "log-guid"),
+			Annotation:    annotation,
+		}
+	}
+
+	fingerprint := func(processGuid, etag string) cc_messages.CCDesiredAppFingerprint {
+		return cc_messages.CCDesiredAppFingerprint{ProcessGuid: processGuid, ETag: etag}
+	}
+
+	BeforeEach(func() {
+		schedulingInfos = []*models.DesiredLRPSchedulingInfo{
+			schedulingInfo("current", cc_messages.AppLRPDomain, "etag-1"),
+			schedulingInfo("outdated", cc_messages.AppLRPDomain, "etag-1"),
+			schedulingInfo("undesired-b", cc_messages.AppLRPDomain, "etag-1"),
+			schedulingInfo("undesired-a", cc_messages.AppLRPDomain, "etag-1"),
+			schedulingInfo("other-domain", "other-domain", "etag-1"),
+		}
+	})
+
+	JustBeforeEach(func() {
+		reconciler = bulk.NewDesiredStateReconciler(schedulingInfos)
+	})
+
+	It("computes creates and updates page by page and deletes once complete", func() {
+		diff, err := reconciler.AddPage(cc_messages.CCDesiredStateFingerprintResponse{
+			Fingerprints: []cc_messages.CCDesiredAppFingerprint{
+				fingerprint("current", "etag-1"),
+				fingerprint("new", "etag-1"),
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal(bulk.DesiredStateDiff{
+			Create: []cc_messages.CCDesiredAppFingerprint{fingerprint("new", "etag-1")},
+		}))
+
+		diff, err = reconciler.AddPage(cc_messages.CCDesiredStateFingerprintResponse{
+			Fingerprints: []cc_messages.CCDesiredAppFingerprint{
+				fingerprint("outdated", "etag-2"),
+				fingerprint("other-domain", "etag-1"),
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal(bulk.DesiredStateDiff{
+			Create: []cc_messages.CCDesiredAppFingerprint{fingerprint("other-domain", "etag-1")},
+			Update: []cc_messages.CCDesiredAppFingerprint{fingerprint("outdated", "etag-2")},
+		}))
+
+		diff, err = reconciler.Finish(true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal(bulk.DesiredStateDiff{
+			Delete: []string{"undesired-a", "undesired-b"},
+		}))
+	})
+
+	It("reports unseen DesiredLRPs as unconfirmed when the listing is incomplete", func() {
+		_, err := reconciler.Add(fingerprint("current", "etag-1"), fingerprint("outdated", "etag-1"))
+		Expect(err).NotTo(HaveOccurred())
+
+		diff, err := reconciler.Finish(false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff.Delete).To(BeEmpty())
+		Expect(diff.Unconfirmed).To(Equal([]string{"undesired-a", "undesired-b"}))
+	})
+
+	It("reports a process guid repeated across pages once", func() {
+		diff, err := reconciler.Add(fingerprint("new", "etag-1"), fingerprint("outdated", "etag-2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff.Create).To(HaveLen(1))
+		Expect(diff.Update).To(HaveLen(1))
+
+		diff, err = reconciler.Add(fingerprint("new", "etag-1"), fingerprint("outdated", "etag-2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff.Empty()).To(BeTrue())
+	})
+
+	It("cannot be used once finished", func() {
+		_, err := reconciler.Finish(true)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = reconciler.Add(fingerprint("new", "etag-1"))
+		Expect(err).To(Equal(bulk.ErrReconcilerFinished))
+
+		_, err = reconciler.Finish(true)
+		Expect(err).To(Equal(bulk.ErrReconcilerFinished))
+	})
+
+	Context("with many apps", func() {
+		BeforeEach(func() {
+			schedulingInfos = nil
+			for i := 0; i < 100000; i++ {
+				schedulingInfos = append(schedulingInfos, schedulingInfo(fmt.Sprintf("app-%d", i), cc_messages.AppLRPDomain, "etag"))
+			}
+		})
+
+		It("reconciles them page by page", func() {
+			var creates, updates int
+			for page := 0; page < 100; page++ {
+				fingerprints := make([]cc_messages.CCDesiredAppFingerprint, 0, 1000)
+				for i := page * 1000; i < (page+1)*1000; i++ {
+					etag := "etag"
+					if i%10 == 0 {
+						etag = "new-etag"
+					}
+					fingerprints = append(fingerprints, fingerprint(fmt.Sprintf("app-%d", i+500), etag))
+				}
+
+				diff, err := reconciler.Add(fingerprints...)
+				Expect(err).NotTo(HaveOccurred())
+				creates += len(diff.Create)
+				updates += len(diff.Update)
+			}
+
+			diff, err := reconciler.Finish(true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creates).To(Equal(500))
+			Expect(updates).To(Equal(9950))
+			Expect(diff.Delete).To(HaveLen(500))
+		})
+	})
+
+	Describe("ReconcileDesiredState", func() {
+		It("reconciles a complete listing", func() {
+			diff := bulk.ReconcileDesiredState(schedulingInfos, []cc_messages.CCDesiredAppFingerprint{
+				fingerprint("current", "etag-1"),
+				fingerprint("outdated", "etag-2"),
+				fingerprint("new", "etag-1"),
+			})
+
+			Expect(diff).To(Equal(bulk.DesiredStateDiff{
+				Create: []cc_messages.CCDesiredAppFingerprint{fingerprint("new", "etag-1")},
+				Update: []cc_messages.CCDesiredAppFingerprint{fingerprint("outdated", "etag-2")},
+				Delete: []string{"undesired-a", "undesired-b"},
+			}))
+		})
+	})
+})
@@ -0,0 +1 @@
+package bulk // import "code.cloudfoundry.org/runtimeschema/cc_messages/bulk"
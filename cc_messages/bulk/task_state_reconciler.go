@@ -0,0 +1,258 @@
+package bulk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+const TaskNotFoundFailureReason = "task not found in diego"
+
+type InvalidCCTaskStateError struct {
+	TaskGuid string
+	State    cc_messages.TaskState
+}
+
+func (e InvalidCCTaskStateError) Error() string {
+	return fmt.Sprintf("invalid state %q for task %s", e.State, e.TaskGuid)
+}
+
+// TaskCallback is a completion callback to send to the CC.
+type TaskCallback struct {
+	CompletionCallbackUrl string
+	Response              models.TaskCallbackResponse
+}
+
+// TaskStatePlan is the work needed to bring the tasks in the
+// RunningTaskDomain and the CC's task states in line. Callers execute it
+// with Execute or inspect it with Steps.
+type TaskStatePlan struct {
+	// Cancel holds the guids of active tasks the CC no longer wants run.
+	Cancel []string
+
+	// ResendCallbacks holds the completion callbacks of tasks Diego
+	// completed while the CC still considers them RUNNING. Tasks Diego is
+	// resolving are left out, as their callback is already in flight.
+	ResendCallbacks []TaskCallback
+
+	// Fail holds failure callbacks for tasks the CC considers PENDING but
+	// Diego does not know about.
+	Fail []TaskCallback
+}
+
+func (p TaskStatePlan) Empty() bool {
+	return len(p.Cancel) == 0 && len(p.ResendCallbacks) == 0 && len(p.Fail) == 0
+}
+
+// Steps describes the plan without executing it, one line per step.
+func (p TaskStatePlan) Steps() []string {
+	var steps []string
+	for _, taskGuid := range p.Cancel {
+		steps = append(steps, fmt.Sprintf("cancel task %s", taskGuid))
+	}
+	for _, callback := range p.ResendCallbacks {
+		steps = append(steps, fmt.Sprintf("resend completion callback for task %s to %s", callback.Response.TaskGuid, callback.CompletionCallbackUrl))
+	}
+	for _, callback := range p.Fail {
+		steps = append(steps, fmt.Sprintf("fail task %s via %s: %s", callback.Response.TaskGuid, callback.CompletionCallbackUrl, callback.Response.FailureReason))
+	}
+	return steps
+}
+
+type TaskStatePlanExecutor interface {
+	CancelTask(taskGuid string) error
+	SendCallback(callback TaskCallback) error
+}
+
+// TaskStatePlanError collects the steps of a plan that failed to execute.
+type TaskStatePlanError []error
+
+func (e TaskStatePlanError) Error() string {
+	strs := make([]string, 0, len(e))
+	for _, err := range e {
+		strs = append(strs, err.Error())
+	}
+	return fmt.Sprintf("%d task state plan steps failed: %s", len(e), strings.Join(strs, ", "))
+}
+
+// Execute runs every step of the plan, continuing past steps that fail. It
+// returns a TaskStatePlanError when any step failed.
+func (p TaskStatePlan) Execute(executor TaskStatePlanExecutor) error {
+	var errs TaskStatePlanError
+
+	for _, taskGuid := range p.Cancel {
+		err := executor.CancelTask(taskGuid)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cancel task %s: %s", taskGuid, err))
+		}
+	}
+
+	for _, callbacks := range [][]TaskCallback{p.ResendCallbacks, p.Fail} {
+		for _, callback := range callbacks {
+			err := executor.SendCallback(callback)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("send callback for task %s: %s", callback.Response.TaskGuid, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// TaskStateReconciler compares the CC's task states with the tasks in the
+// RunningTaskDomain one page at a time.
+type TaskStateReconciler struct {
+	tasks    map[string]*models.Task
+	seen     map[string]struct{}
+	plan     TaskStatePlan
+	finished bool
+}
+
+// NewTaskStateReconciler starts a reconciliation against tasks. Tasks
+// outside the RunningTaskDomain are ignored.
+func NewTaskStateReconciler(tasks []*models.Task) *TaskStateReconciler {
+	byGuid := make(map[string]*models.Task, len(tasks))
+	for _, task := range tasks {
+		if task.Domain != cc_messages.RunningTaskDomain {
+			continue
+		}
+		byGuid[task.TaskGuid] = task
+	}
+
+	return &TaskStateReconciler{
+		tasks: byGuid,
+		seen:  map[string]struct{}{},
+	}
+}
+
+func (r *TaskStateReconciler) AddPage(page cc_messages.CCTaskStatesResponse) error {
+	return r.Add(page.TaskStates...)
+}
+
+// Add reconciles task states from the CC listing. A task guid repeated
+// across pages is only reconciled the first time. It returns an
+// InvalidCCTaskStateError, reconciling none of states, when any of them is
+// not a valid TaskState.
+func (r *TaskStateReconciler) Add(states ...cc_messages.CCTaskState) error {
+	if r.finished {
+		return ErrReconcilerFinished
+	}
+
+	for _, state := range states {
+		if !state.State.Valid() {
+			return InvalidCCTaskStateError{TaskGuid: state.TaskGuid, State: state.State}
+		}
+	}
+
+	for _, state := range states {
+		if _, seen := r.seen[state.TaskGuid]; seen {
+			continue
+		}
+		r.seen[state.TaskGuid] = struct{}{}
+
+		r.reconcile(state)
+	}
+
+	return nil
+}
+
+// reconcile plans a single task: tasks the CC is done with or cancelling
+// are cancelled while Diego still runs them, tasks Diego completed get their
+// callback resent while the CC still thinks they are RUNNING, and PENDING
+// tasks Diego does not know about are failed.
+func (r *TaskStateReconciler) reconcile(state cc_messages.CCTaskState) {
+	task, ok := r.tasks[state.TaskGuid]
+	if !ok {
+		if state.State == cc_messages.TaskStatePending {
+			r.plan.Fail = append(r.plan.Fail, TaskCallback{
+				CompletionCallbackUrl: state.CompletionCallbackUrl,
+				Response: models.TaskCallbackResponse{
+					TaskGuid:      state.TaskGuid,
+					Failed:        true,
+					FailureReason: TaskNotFoundFailureReason,
+				},
+			})
+		}
+		return
+	}
+
+	switch {
+	case isTaskActive(task) && (state.State == cc_messages.TaskStateCanceling || state.State.Terminal()):
+		r.plan.Cancel = append(r.plan.Cancel, task.TaskGuid)
+	case task.State == models.Task_Completed && state.State == cc_messages.TaskStateRunning:
+		r.plan.ResendCallbacks = append(r.plan.ResendCallbacks, taskCallback(task, state))
+	}
+}
+
+// Finish ends the reconciliation and returns the plan. When complete is
+// true every page of the listing was added and active tasks the CC did not
+// list are cancelled; otherwise they are left alone.
+func (r *TaskStateReconciler) Finish(complete bool) (TaskStatePlan, error) {
+	if r.finished {
+		return TaskStatePlan{}, ErrReconcilerFinished
+	}
+	r.finished = true
+
+	plan := r.plan
+	if complete {
+		var unknown []string
+		for taskGuid, task := range r.tasks {
+			if _, seen := r.seen[taskGuid]; !seen && isTaskActive(task) {
+				unknown = append(unknown, taskGuid)
+			}
+		}
+		sort.Strings(unknown)
+		plan.Cancel = append(plan.Cancel, unknown...)
+	}
+
+	r.tasks = nil
+	r.seen = nil
+	r.plan = TaskStatePlan{}
+
+	return plan, nil
+}
+
+// ReconcileTaskStates reconciles a complete listing held in memory.
+func ReconcileTaskStates(tasks []*models.Task, states []cc_messages.CCTaskState) (TaskStatePlan, error) {
+	reconciler := NewTaskStateReconciler(tasks)
+	err := reconciler.Add(states...)
+	if err != nil {
+		return TaskStatePlan{}, err
+	}
+	return reconciler.Finish(true)
+}
+
+func isTaskActive(task *models.Task) bool {
+	state, err := cc_messages.TaskStateFromBBSTask(task)
+	return err == nil && !state.Terminal()
+}
+
+func taskCallback(task *models.Task, state cc_messages.CCTaskState) TaskCallback {
+	callbackURL := state.CompletionCallbackUrl
+	if callbackURL == "" && task.TaskDefinition != nil {
+		callbackURL = task.CompletionCallbackUrl
+	}
+
+	var annotation string
+	if task.TaskDefinition != nil {
+		annotation = task.Annotation
+	}
+
+	return TaskCallback{
+		CompletionCallbackUrl: callbackURL,
+		Response: models.TaskCallbackResponse{
+			TaskGuid:      task.TaskGuid,
+			Failed:        task.Failed,
+			FailureReason: task.FailureReason,
+			Result:        task.Result,
+			Annotation:    annotation,
+			CreatedAt:     task.CreatedAt,
+		},
+	}
+}
@@ -0,0 +1,218 @@
+package bulk_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/bulk"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeTaskStatePlanExecutor struct {
+	cancelled []string
+	callbacks []bulk.TaskCallback
+	err       error
+}
+
+func (e *fakeTaskStatePlanExecutor) CancelTask(taskGuid string) error {
+	e.cancelled = append(e.cancelled, taskGuid)
+	return e.err
+}
+
+func (e *fakeTaskStatePlanExecutor) SendCallback(callback bulk.TaskCallback) error {
+	e.callbacks = append(e.callbacks, callback)
+	return e.err
+}
+
+var _ = Describe("TaskStateReconciler", func() {
+	var (
+		tasks      []*models.Task
+		reconciler *bulk.TaskStateReconciler
+	)
+
+	task := func(taskGuid, domain string, state models.Task_State) *models.Task {
+		return &models.Task{
+			TaskGuid: taskGuid,
+			Domain:   domain,
+			State:    state,
+			TaskDefinition: &models.TaskDefinition{
+				CompletionCallbackUrl: "http://diego.callback/" + taskGuid,
+				Annotation:            "annotation-" + taskGuid,
+			},
+			CreatedAt: 1234,
+		}
+	}
+
+	ccTaskState := func(taskGuid string, state cc_messages.TaskState) cc_messages.CCTaskState {
+		return cc_messages.CCTaskState{
+			TaskGuid:              taskGuid,
+			State:                 state,
+			CompletionCallbackUrl: "http://cc.callback/" + taskGuid,
+		}
+	}
+
+	BeforeEach(func() {
+		completed := task("completed", cc_messages.RunningTaskDomain, models.Task_Completed)
+		completed.Result = "the-result"
+		resolving := task("resolving", cc_messages.RunningTaskDomain, models.Task_Resolving)
+		resolving.Failed = true
+		resolving.FailureReason = "exit status 1"
+
+		tasks = []*models.Task{
+			task("running", cc_messages.RunningTaskDomain, models.Task_Running),
+			completed,
+			resolving,
+			task("canceling", cc_messages.RunningTaskDomain, models.Task_Running),
+			task("succeeded", cc_messages.RunningTaskDomain, models.Task_Pending),
+			task("failed", cc_messages.RunningTaskDomain, models.Task_Running),
+			task("unknown-b", cc_messages.RunningTaskDomain, models.Task_Running),
+			task("unknown-a", cc_messages.RunningTaskDomain, models.Task_Pending),
+			task("unknown-completed", cc_messages.RunningTaskDomain, models.Task_Completed),
+			task("staging", cc_messages.StagingTaskDomain, models.Task_Running),
+		}
+	})
+
+	JustBeforeEach(func() {
+		reconciler = bulk.NewTaskStateReconciler(tasks)
+
+		err := reconciler.AddPage(cc_messages.CCTaskStatesResponse{
+			TaskStates: []cc_messages.CCTaskState{
+				ccTaskState("running", cc_messages.TaskStateRunning),
+				ccTaskState("completed", cc_messages.TaskStateRunning),
+				ccTaskState("resolving", cc_messages.TaskStateRunning),
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = reconciler.Add(
+			ccTaskState("canceling", cc_messages.TaskStateCanceling),
+			ccTaskState("succeeded", cc_messages.TaskStateSucceeded),
+			ccTaskState("failed", cc_messages.TaskStateFailed),
+			ccTaskState("orphaned-pending", cc_messages.TaskStatePending),
+			ccTaskState("orphaned-running", cc_messages.TaskStateRunning),
+			ccTaskState("orphaned-canceling", cc_messages.TaskStateCanceling),
+			ccTaskState("orphaned-succeeded", cc_messages.TaskStateSucceeded),
+			ccTaskState("completed", cc_messages.TaskStateRunning),
+		)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("plans cancels, resent callbacks and failures", func() {
+		plan, err := reconciler.Finish(true)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(plan.Cancel).To(Equal([]string{"canceling", "succeeded", "failed", "unknown-a", "unknown-b"}))
+		Expect(plan.ResendCallbacks).To(Equal([]bulk.TaskCallback{
+			{
+				CompletionCallbackUrl: "http://cc.callback/completed",
+				Response: models.TaskCallbackResponse{
+					TaskGuid:   "completed",
+					Result:     "the-result",
+					Annotation: "annotation-completed",
+					CreatedAt:  1234,
+				},
+			},
+		}))
+		Expect(plan.Fail).To(Equal([]bulk.TaskCallback{
+			{
+				CompletionCallbackUrl: "http://cc.callback/orphaned-pending",
+				Response: models.TaskCallbackResponse{
+					TaskGuid:      "orphaned-pending",
+					Failed:        true,
+					FailureReason: bulk.TaskNotFoundFailureReason,
+				},
+			},
+		}))
+	})
+
+	It("rejects invalid task states without reconciling any of them", func() {
+		err := reconciler.Add(
+			ccTaskState("unknown-a", cc_messages.TaskStateSucceeded),
+			ccTaskState("unknown-b", "BOGUS"),
+		)
+		Expect(err).To(Equal(bulk.InvalidCCTaskStateError{TaskGuid: "unknown-b", State: "BOGUS"}))
+
+		err = reconciler.Add(ccTaskState("empty", ""))
+		Expect(err).To(Equal(bulk.InvalidCCTaskStateError{TaskGuid: "empty", State: ""}))
+
+		plan, err := reconciler.Finish(false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Cancel).NotTo(ContainElement("unknown-a"))
+	})
+
+	It("leaves unlisted tasks alone when the listing is incomplete", func() {
+		plan, err := reconciler.Finish(false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Cancel).To(Equal([]string{"canceling", "succeeded", "failed"}))
+	})
+
+	It("cannot be used once finished", func() {
+		_, err := reconciler.Finish(true)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(reconciler.Add(ccTaskState("running", cc_messages.TaskStateRunning))).To(Equal(bulk.ErrReconcilerFinished))
+		_, err = reconciler.Finish(true)
+		Expect(err).To(Equal(bulk.ErrReconcilerFinished))
+	})
+
+	Describe("TaskStatePlan", func() {
+		var plan bulk.TaskStatePlan
+
+		JustBeforeEach(func() {
+			var err error
+			plan, err = reconciler.Finish(false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("describes its steps for a dry run", func() {
+			Expect(plan.Steps()).To(Equal([]string{
+				"cancel task canceling",
+				"cancel task succeeded",
+				"cancel task failed",
+				"resend completion callback for task completed to http://cc.callback/completed",
+				"fail task orphaned-pending via http://cc.callback/orphaned-pending: " + bulk.TaskNotFoundFailureReason,
+			}))
+		})
+
+		It("executes every step", func() {
+			executor := &fakeTaskStatePlanExecutor{}
+			Expect(plan.Execute(executor)).To(Succeed())
+
+			Expect(executor.cancelled).To(Equal([]string{"canceling", "succeeded", "failed"}))
+			Expect(executor.callbacks).To(HaveLen(2))
+			Expect(executor.callbacks[1].Response.TaskGuid).To(Equal("orphaned-pending"))
+		})
+
+		It("keeps executing past failed steps", func() {
+			executor := &fakeTaskStatePlanExecutor{err: errors.New("boom")}
+
+			err := plan.Execute(executor)
+			Expect(err).To(BeAssignableToTypeOf(bulk.TaskStatePlanError{}))
+			Expect(err.(bulk.TaskStatePlanError)).To(HaveLen(5))
+			Expect(err.Error()).To(HavePrefix("5 task state plan steps failed: cancel task canceling: boom"))
+			Expect(executor.callbacks).To(HaveLen(2))
+		})
+	})
+
+	Describe("ReconcileTaskStates", func() {
+		It("reconciles a complete listing", func() {
+			plan, err := bulk.ReconcileTaskStates(tasks, []cc_messages.CCTaskState{
+				ccTaskState("running", cc_messages.TaskStateRunning),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Cancel).To(ContainElement("unknown-a"))
+			Expect(plan.Cancel).NotTo(ContainElement("staging"))
+			Expect(plan.Cancel).NotTo(ContainElement("running"))
+			Expect(plan.Fail).To(BeEmpty())
+			Expect(plan.Empty()).To(BeFalse())
+		})
+
+		It("returns invalid task states", func() {
+			_, err := bulk.ReconcileTaskStates(tasks, []cc_messages.CCTaskState{ccTaskState("running", "BOGUS")})
+			Expect(err).To(Equal(bulk.InvalidCCTaskStateError{TaskGuid: "running", State: "BOGUS"}))
+		})
+	})
+})
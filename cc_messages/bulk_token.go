@@ -0,0 +1,87 @@
+package cc_messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CCBulkTokenVersion is the version of the cursors written by
+// NewCCBulkToken. Cursors in the original {"id": N} form decode with
+// version 0.
+const CCBulkTokenVersion = 1
+
+type UnsupportedBulkTokenVersionError struct {
+	Version int
+}
+
+func (e UnsupportedBulkTokenVersionError) Error() string {
+	return fmt.Sprintf("unsupported bulk token version %d, at most %d is supported", e.Version, CCBulkTokenVersion)
+}
+
+// CCBulkToken is the cursor of the bulk listings. Snapshot, in nanoseconds
+// since the epoch, identifies the listing the cursor belongs to: the CC
+// restarted the listing when consecutive cursors carry different snapshots.
+type CCBulkToken struct {
+	Id       int   `json:"id"`
+	Version  int   `json:"version,omitempty"`
+	Snapshot int64 `json:"snapshot,omitempty"`
+}
+
+func NewCCBulkToken(id int, snapshot time.Time) CCBulkToken {
+	token := CCBulkToken{Id: id, Version: CCBulkTokenVersion}
+	if !snapshot.IsZero() {
+		token.Snapshot = snapshot.UnixNano()
+	}
+	return token
+}
+
+// Encode returns the token in the raw form the bulk responses carry. A
+// version 0 token without a snapshot encodes as {"id": N}.
+func (t CCBulkToken) Encode() (*json.RawMessage, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := json.RawMessage(payload)
+	return &raw, nil
+}
+
+func (t CCBulkToken) HasSnapshot() bool {
+	return t.Snapshot != 0
+}
+
+func (t CCBulkToken) SnapshotTime() time.Time {
+	if !t.HasSnapshot() {
+		return time.Time{}
+	}
+	return time.Unix(0, t.Snapshot)
+}
+
+// SameListing reports whether t and next can belong to the same listing.
+// Tokens without a snapshot are assumed to.
+func (t CCBulkToken) SameListing(next CCBulkToken) bool {
+	return !t.HasSnapshot() || !next.HasSnapshot() || t.Snapshot == next.Snapshot
+}
+
+// DecodeCCBulkToken decodes a raw bulk token. It returns nil for the nil or
+// JSON null token that ends a listing.
+func DecodeCCBulkToken(raw *json.RawMessage) (*CCBulkToken, error) {
+	if raw == nil || bytes.Equal(bytes.TrimSpace(*raw), []byte("null")) {
+		return nil, nil
+	}
+
+	var token CCBulkToken
+	err := json.Unmarshal(*raw, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Version > CCBulkTokenVersion || token.Version < 0 {
+		return nil, UnsupportedBulkTokenVersionError{Version: token.Version}
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,86 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CCBulkToken", func() {
+	rawMessage := func(payload string) *json.RawMessage {
+		message := json.RawMessage(payload)
+		return &message
+	}
+
+	It("encodes the version and snapshot", func() {
+		raw, err := cc_messages.NewCCBulkToken(5, time.Unix(100, 0)).Encode()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*raw).To(MatchJSON(`{"id":5,"version":1,"snapshot":100000000000}`))
+	})
+
+	It("encodes version 0 tokens in the plain form", func() {
+		raw, err := cc_messages.CCBulkToken{Id: 5}.Encode()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*raw).To(MatchJSON(`{"id":5}`))
+	})
+
+	It("omits a zero snapshot", func() {
+		raw, err := cc_messages.NewCCBulkToken(5, time.Time{}).Encode()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*raw).To(MatchJSON(`{"id":5,"version":1}`))
+	})
+
+	Describe("DecodeCCBulkToken", func() {
+		It("decodes the plain form as version 0", func() {
+			token, err := cc_messages.DecodeCCBulkToken(rawMessage(`{"id":5}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*token).To(Equal(cc_messages.CCBulkToken{Id: 5}))
+			Expect(token.HasSnapshot()).To(BeFalse())
+			Expect(token.SnapshotTime().IsZero()).To(BeTrue())
+		})
+
+		It("round-trips versioned tokens", func() {
+			original := cc_messages.NewCCBulkToken(5, time.Unix(100, 0))
+			raw, err := original.Encode()
+			Expect(err).NotTo(HaveOccurred())
+
+			token, err := cc_messages.DecodeCCBulkToken(raw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*token).To(Equal(original))
+			Expect(token.SnapshotTime()).To(Equal(time.Unix(100, 0)))
+		})
+
+		It("returns nil for the token ending a listing", func() {
+			token, err := cc_messages.DecodeCCBulkToken(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).To(BeNil())
+
+			token, err = cc_messages.DecodeCCBulkToken(rawMessage(" null "))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).To(BeNil())
+		})
+
+		It("rejects versions it does not know", func() {
+			_, err := cc_messages.DecodeCCBulkToken(rawMessage(`{"id":5,"version":2}`))
+			Expect(err).To(Equal(cc_messages.UnsupportedBulkTokenVersionError{Version: 2}))
+		})
+
+		It("rejects malformed tokens", func() {
+			_, err := cc_messages.DecodeCCBulkToken(rawMessage(`{"id":"five"}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("SameListing", func() {
+		It("compares snapshots when both tokens carry one", func() {
+			first := cc_messages.NewCCBulkToken(1, time.Unix(100, 0))
+			Expect(first.SameListing(cc_messages.NewCCBulkToken(2, time.Unix(100, 0)))).To(BeTrue())
+			Expect(first.SameListing(cc_messages.NewCCBulkToken(2, time.Unix(200, 0)))).To(BeFalse())
+			Expect(first.SameListing(cc_messages.CCBulkToken{Id: 2})).To(BeTrue())
+		})
+	})
+})
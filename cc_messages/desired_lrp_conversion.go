@@ -0,0 +1,332 @@
+package cc_messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+const (
+	dockerRootFSPrefix    = "docker://"
+	preloadedRootFSPrefix = "preloaded:"
+	sharedDeviceType      = "shared"
+)
+
+// LossyField names a DesireAppRequestFromCC field, by its JSON key, that
+// could not be recovered from a DesiredLRP.
+type LossyField struct {
+	Field  string
+	Reason string
+}
+
+func (f LossyField) String() string {
+	return f.Field + ": " + f.Reason
+}
+
+type LossyFields []LossyField
+
+func (l LossyFields) Append(field, reason string) LossyFields {
+	return append(l, LossyField{Field: field, Reason: reason})
+}
+
+func (l LossyFields) Fields() []string {
+	fields := make([]string, 0, len(l))
+	for _, f := range l {
+		fields = append(fields, f.Field)
+	}
+	return fields
+}
+
+func (l LossyFields) String() string {
+	strs := make([]string, 0, len(l))
+	for _, f := range l {
+		strs = append(strs, f.String())
+	}
+	return strings.Join(strs, ", ")
+}
+
+// DesireAppRequestFromDesiredLRP rebuilds the DesireAppRequestFromCC a
+// DesiredLRP in the AppLRPDomain was desired from. It reverses the recipe
+// the recipebuilder package produces, so rebuilding the DesiredLRP from the
+// returned request yields the original DesiredLRP.
+//
+// Fields the DesiredLRP does not carry, or carries in a shape the recipe
+// never produces, are left empty and reported in the returned LossyFields.
+// Ports and FileDescriptors holding the values the recipe defaults them to
+// are returned but reported too, since the CC may not have sent them.
+// Docker images come back in their docker:// rootfs form and LogSource comes
+// back with its default applied. The diego-ssh route and port the recipe
+// adds for AllowSSH are removed again.
+func DesireAppRequestFromDesiredLRP(lrp *models.DesiredLRP) (DesireAppRequestFromCC, LossyFields) {
+	var lossy LossyFields
+
+	desiredApp := DesireAppRequestFromCC{
+		ProcessGuid:  lrp.ProcessGuid,
+		NumInstances: int(lrp.Instances),
+		MemoryMB:     int(lrp.MemoryMb),
+		DiskMB:       int(lrp.DiskMb),
+		LogGuid:      lrp.LogGuid,
+		ETag:         lrp.Annotation,
+		Ports:        append([]uint32(nil), lrp.Ports...),
+		EgressRules:  lrp.EgressRules,
+		Network:      lrp.Network,
+
+		HealthCheckTimeoutInSeconds: uint(lrp.StartTimeoutMs / 1000),
+	}
+
+	if lrp.StartTimeoutMs%1000 != 0 {
+		lossy = lossy.Append("health_check_timeout_in_seconds", fmt.Sprintf("start timeout of %dms is not a whole number of seconds", lrp.StartTimeoutMs))
+	}
+
+	if lrp.Routes != nil && len(*lrp.Routes) > 0 {
+		routingInfo := CCRouteInfo{}
+		for key, payload := range *lrp.Routes {
+			if key == CC_SSH_ROUTES {
+				desiredApp.AllowSSH = true
+				continue
+			}
+			routingInfo[key] = payload
+		}
+		if len(routingInfo) > 0 {
+			desiredApp.RoutingInfo = routingInfo
+		}
+	}
+	if desiredApp.AllowSSH && len(desiredApp.Ports) > 0 && desiredApp.Ports[len(desiredApp.Ports)-1] == DiegoSSHPort {
+		desiredApp.Ports = desiredApp.Ports[:len(desiredApp.Ports)-1]
+	}
+	if len(desiredApp.Ports) == 0 {
+		desiredApp.Ports = nil
+	}
+
+	switch len(lrp.PlacementTags) {
+	case 0:
+	case 1:
+		desiredApp.IsolationSegment = lrp.PlacementTags[0]
+	default:
+		desiredApp.IsolationSegment = lrp.PlacementTags[0]
+		lossy = lossy.Append("isolation_segment", fmt.Sprintf("desired lrp has %d placement tags", len(lrp.PlacementTags)))
+	}
+
+	if strings.HasPrefix(lrp.RootFs, dockerRootFSPrefix) {
+		desiredApp.DockerImageUrl = lrp.RootFs
+		desiredApp.DockerUser = lrp.ImageUsername
+		desiredApp.DockerPassword = lrp.ImagePassword
+		if IsEncodedSealedCredential(lrp.ImagePassword) {
+			sealed, err := DecodeSealedCredential(lrp.ImagePassword)
+			if err != nil {
+				lossy = lossy.Append("sealed_docker_password", err.Error())
+			} else {
+				desiredApp.SealedDockerPassword = sealed
+			}
+			desiredApp.DockerPassword = ""
+		}
+		lossy = lossy.Append("docker_login_server", "not carried by the desired lrp")
+		lossy = lossy.Append("docker_email", "not carried by the desired lrp")
+	} else {
+		lossy = convertDropletSetup(lrp, &desiredApp, lossy)
+	}
+
+	lossy = convertRunAction(lrp, &desiredApp, lossy)
+	lossy = convertMonitorAction(lrp, &desiredApp, lossy)
+	lossy = convertDesiredVolumeMounts(lrp, &desiredApp, lossy)
+
+	if isRecipeDefaultPorts(&desiredApp) {
+		lossy = lossy.Append("ports", "matches the ports the recipe defaults to")
+	}
+	if desiredApp.FileDescriptors == DefaultAppFileDescriptors {
+		lossy = lossy.Append("file_descriptors", "matches the limit the recipe defaults to")
+	}
+
+	return desiredApp, lossy
+}
+
+// isRecipeDefaultPorts reports whether the ports are the ones the recipe
+// uses when the CC sends none: the tcp ports a docker image exposes, or
+// else the default port.
+func isRecipeDefaultPorts(desiredApp *DesireAppRequestFromCC) bool {
+	defaultPorts := []uint32{DefaultAppPort}
+	if desiredApp.DockerImageUrl != "" {
+		var executionMetadata struct {
+			Ports []struct {
+				Port     uint32
+				Protocol string
+			} `json:"ports"`
+		}
+		if json.Unmarshal([]byte(desiredApp.ExecutionMetadata), &executionMetadata) == nil {
+			var exposed []uint32
+			for _, port := range executionMetadata.Ports {
+				if port.Protocol == "tcp" {
+					exposed = append(exposed, port.Port)
+				}
+			}
+			if len(exposed) > 0 {
+				defaultPorts = exposed
+			}
+		}
+	}
+
+	if len(desiredApp.Ports) != len(defaultPorts) {
+		return false
+	}
+	for i := range defaultPorts {
+		if desiredApp.Ports[i] != defaultPorts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func convertDropletSetup(lrp *models.DesiredLRP, desiredApp *DesireAppRequestFromCC, lossy LossyFields) LossyFields {
+	desiredApp.Stack = lifecycleStack(lrp)
+	if desiredApp.Stack == "" {
+		lossy = lossy.Append("stack", fmt.Sprintf("cannot be derived from rootfs %q", lrp.RootFs))
+	}
+
+	var download *models.DownloadAction
+	if setup := lrp.Setup; setup != nil {
+		download = setup.GetDownloadAction()
+		if serial := setup.GetSerialAction(); serial != nil && len(serial.Actions) == 1 {
+			download = serial.Actions[0].GetDownloadAction()
+		}
+	}
+	if download == nil {
+		lossy = lossy.Append("droplet_uri", "setup does not download a droplet")
+		return lossy
+	}
+
+	desiredApp.DropletUri = download.From
+	if download.ChecksumAlgorithm == "sha1" {
+		desiredApp.DropletHash = download.ChecksumValue
+	} else if download.ChecksumValue != "" {
+		lossy = lossy.Append("droplet_hash", fmt.Sprintf("unsupported checksum algorithm %q", download.ChecksumAlgorithm))
+	}
+
+	return lossy
+}
+
+// lifecycleStack prefers the stack named by the buildpack lifecycle
+// dependency, whose cache key is "buildpack-<stack>-lifecycle", and falls
+// back to the stack of a preloaded rootfs.
+func lifecycleStack(lrp *models.DesiredLRP) string {
+	for _, dependency := range lrp.CachedDependencies {
+		cacheKey := dependency.GetCacheKey()
+		prefix, suffix := BUILDPACK_LIFECYCLE+"-", "-lifecycle"
+		if strings.HasPrefix(cacheKey, prefix) && strings.HasSuffix(cacheKey, suffix) && len(cacheKey) > len(prefix)+len(suffix) {
+			return cacheKey[len(prefix) : len(cacheKey)-len(suffix)]
+		}
+	}
+
+	if strings.HasPrefix(lrp.RootFs, preloadedRootFSPrefix) {
+		return strings.TrimPrefix(lrp.RootFs, preloadedRootFSPrefix)
+	}
+
+	return ""
+}
+
+func convertRunAction(lrp *models.DesiredLRP, desiredApp *DesireAppRequestFromCC, lossy LossyFields) LossyFields {
+	var run *models.RunAction
+	var sshd bool
+	if action := lrp.Action; action != nil {
+		run = action.GetRunAction()
+		if codependent := action.GetCodependentAction(); codependent != nil {
+			switch len(codependent.Actions) {
+			case 2:
+				sshd = codependent.Actions[1].GetRunAction().GetPath() == DiegoSSHDPath
+				if !sshd {
+					break
+				}
+				fallthrough
+			case 1:
+				run = codependent.Actions[0].GetRunAction()
+			}
+		}
+	}
+	if sshd != desiredApp.AllowSSH {
+		lossy = lossy.Append("allow_ssh", "the diego-ssh route and the diego-sshd action disagree")
+	}
+	if run == nil || len(run.Args) != 3 {
+		return lossy.Append("start_command", "action does not run the launcher").
+			Append("execution_metadata", "action does not run the launcher").
+			Append("environment", "action does not run the launcher")
+	}
+
+	desiredApp.StartCommand = run.Args[1]
+	desiredApp.ExecutionMetadata = run.Args[2]
+	desiredApp.LogSource = run.LogSource
+
+	env := run.Env
+	if len(env) > 0 && env[len(env)-1] != nil && env[len(env)-1].Name == "PORT" {
+		env = env[:len(env)-1]
+		lossy = lossy.Append("environment", "PORT is set by the recipe, replacing any PORT the app set")
+	}
+	if len(env) > 0 {
+		desiredApp.Environment = env
+	}
+
+	if run.ResourceLimits != nil && run.ResourceLimits.NofileExists() {
+		desiredApp.FileDescriptors = run.ResourceLimits.GetNofile()
+	}
+
+	return lossy
+}
+
+func convertMonitorAction(lrp *models.DesiredLRP, desiredApp *DesireAppRequestFromCC, lossy LossyFields) LossyFields {
+	if lrp.Monitor == nil {
+		desiredApp.HealthCheckType = NoneHealthCheckType
+		return lossy
+	}
+
+	var checks []*models.Action
+	if timeout := lrp.Monitor.GetTimeoutAction(); timeout != nil && timeout.Action != nil {
+		if parallel := timeout.Action.GetParallelAction(); parallel != nil {
+			checks = parallel.Actions
+		}
+	}
+	if len(checks) == 0 {
+		return lossy.Append("health_check_type", "monitor does not run the healthcheck")
+	}
+
+	desiredApp.HealthCheckType = PortHealthCheckType
+	for _, check := range checks {
+		for _, arg := range check.GetRunAction().GetArgs() {
+			if strings.HasPrefix(arg, "-uri=") {
+				desiredApp.HealthCheckType = HTTPHealthCheckType
+				desiredApp.HealthCheckHTTPEndpoint = strings.TrimPrefix(arg, "-uri=")
+			}
+		}
+	}
+
+	return lossy
+}
+
+func convertDesiredVolumeMounts(lrp *models.DesiredLRP, desiredApp *DesireAppRequestFromCC, lossy LossyFields) LossyFields {
+	for i, mount := range lrp.VolumeMounts {
+		if mount.Shared == nil {
+			lossy = lossy.Append(fmt.Sprintf("volume_mounts[%d]", i), "is not a shared device")
+			continue
+		}
+
+		var mountConfig map[string]interface{}
+		if mount.Shared.MountConfig != "" {
+			err := json.Unmarshal([]byte(mount.Shared.MountConfig), &mountConfig)
+			if err != nil {
+				lossy = lossy.Append(fmt.Sprintf("volume_mounts[%d].device.mount_config", i), "is malformed")
+			}
+		}
+
+		desiredApp.VolumeMounts = append(desiredApp.VolumeMounts, &VolumeMount{
+			Driver:       mount.Driver,
+			ContainerDir: mount.ContainerDir,
+			Mode:         mount.Mode,
+			DeviceType:   sharedDeviceType,
+			Device: SharedDevice{
+				VolumeId:    mount.Shared.VolumeId,
+				MountConfig: mountConfig,
+			},
+		})
+	}
+
+	return lossy
+}
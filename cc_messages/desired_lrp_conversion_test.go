@@ -0,0 +1,304 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/flags"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/recipebuilder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DesiredLRPConversion", func() {
+	var desiredLRP *models.DesiredLRP
+
+	BeforeEach(func() {
+		routes := models.Routes{}
+		routesJson := json.RawMessage(`[{"hostname":"route1"}]`)
+		routes[cc_messages.CC_HTTP_ROUTES] = &routesJson
+
+		limits := &models.ResourceLimits{}
+		limits.SetNofile(32)
+
+		desiredLRP = &models.DesiredLRP{
+			ProcessGuid:    "process-guid",
+			Domain:         cc_messages.AppLRPDomain,
+			Instances:      3,
+			RootFs:         "preloaded:some-stack",
+			MemoryMb:       128,
+			DiskMb:         512,
+			Ports:          []uint32{8080, 9090},
+			Routes:         &routes,
+			LogGuid:        "log-guid",
+			Annotation:     "etag",
+			StartTimeoutMs: 60000,
+			PlacementTags:  []string{"segment"},
+			CachedDependencies: []*models.CachedDependency{
+				{From: "http://file-server.com/lifecycle.tgz", To: "/tmp/lifecycle", CacheKey: "buildpack-some-stack-lifecycle"},
+			},
+			Setup: models.WrapAction(models.Serial(&models.DownloadAction{
+				From:              "http://droplet.uri",
+				To:                ".",
+				ChecksumAlgorithm: "sha1",
+				ChecksumValue:     "droplet-hash",
+			})),
+			Action: models.WrapAction(models.Codependent(&models.RunAction{
+				Path:      "/tmp/lifecycle/launcher",
+				Args:      []string{"app", "start", "{}"},
+				LogSource: "APP",
+				Env: []*models.EnvironmentVariable{
+					{Name: "FOO", Value: "BAR"},
+					{Name: "PORT", Value: "8080"},
+				},
+				ResourceLimits: limits,
+			})),
+			Monitor: models.WrapAction(models.Timeout(models.Parallel(&models.RunAction{
+				Path: "/tmp/lifecycle/healthcheck",
+				Args: []string{"-port=8080"},
+			}), time.Minute)),
+			VolumeMounts: []*models.VolumeMount{
+				{
+					Driver:       "nfs",
+					ContainerDir: "/data",
+					Mode:         "r",
+					Shared:       &models.SharedDevice{VolumeId: "volume-id", MountConfig: `{"source":"server:/export"}`},
+				},
+			},
+		}
+	})
+
+	It("rebuilds the desire app request", func() {
+		desiredApp, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+		Expect(lossy).To(Equal(cc_messages.LossyFields{
+			{Field: "environment", Reason: "PORT is set by the recipe, replacing any PORT the app set"},
+		}))
+
+		Expect(desiredApp).To(Equal(cc_messages.DesireAppRequestFromCC{
+			ProcessGuid:                 "process-guid",
+			DropletUri:                  "http://droplet.uri",
+			DropletHash:                 "droplet-hash",
+			Stack:                       "some-stack",
+			StartCommand:                "start",
+			ExecutionMetadata:           "{}",
+			Environment:                 []*models.EnvironmentVariable{{Name: "FOO", Value: "BAR"}},
+			MemoryMB:                    128,
+			DiskMB:                      512,
+			FileDescriptors:             32,
+			NumInstances:                3,
+			RoutingInfo:                 cc_messages.CCRouteInfo(*desiredLRP.Routes),
+			LogGuid:                     "log-guid",
+			LogSource:                   "APP",
+			HealthCheckType:             cc_messages.PortHealthCheckType,
+			HealthCheckTimeoutInSeconds: 60,
+			ETag:                        "etag",
+			Ports:                       []uint32{8080, 9090},
+			IsolationSegment:            "segment",
+			VolumeMounts: []*cc_messages.VolumeMount{
+				{
+					Driver:       "nfs",
+					ContainerDir: "/data",
+					Mode:         "r",
+					DeviceType:   "shared",
+					Device: cc_messages.SharedDevice{
+						VolumeId:    "volume-id",
+						MountConfig: map[string]interface{}{"source": "server:/export"},
+					},
+				},
+			},
+		}))
+	})
+
+	It("recovers the http health check endpoint", func() {
+		desiredLRP.Monitor = models.WrapAction(models.Timeout(models.Parallel(&models.RunAction{
+			Path: "/tmp/lifecycle/healthcheck",
+			Args: []string{"-port=8080", "-uri=/health"},
+		}), time.Minute))
+
+		desiredApp, _ := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+		Expect(desiredApp.HealthCheckType).To(Equal(cc_messages.HTTPHealthCheckType))
+		Expect(desiredApp.HealthCheckHTTPEndpoint).To(Equal("/health"))
+	})
+
+	It("maps a missing monitor to the none health check", func() {
+		desiredLRP.Monitor = nil
+
+		desiredApp, _ := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+		Expect(desiredApp.HealthCheckType).To(Equal(cc_messages.NoneHealthCheckType))
+	})
+
+	Describe("ssh", func() {
+		BeforeEach(func() {
+			sshJson := json.RawMessage(`{"container_port":2222}`)
+			(*desiredLRP.Routes)[cc_messages.CC_SSH_ROUTES] = &sshJson
+			desiredLRP.Ports = append(desiredLRP.Ports, 2222)
+		})
+
+		It("allows ssh and drops the route and port the recipe added", func() {
+			run := desiredLRP.Action.GetCodependentAction().Actions[0].GetRunAction()
+			desiredLRP.Action = models.WrapAction(models.Codependent(run, &models.RunAction{Path: "/tmp/lifecycle/diego-sshd"}))
+
+			desiredApp, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+			Expect(lossy.Fields()).To(Equal([]string{"environment"}))
+			Expect(desiredApp.AllowSSH).To(BeTrue())
+			Expect(desiredApp.RoutingInfo).NotTo(HaveKey(cc_messages.CC_SSH_ROUTES))
+			Expect(desiredApp.RoutingInfo).To(HaveKey(cc_messages.CC_HTTP_ROUTES))
+			Expect(desiredApp.Ports).To(Equal([]uint32{8080, 9090}))
+			Expect(desiredApp.StartCommand).To(Equal("start"))
+		})
+
+		It("reports an ssh route without diego-sshd", func() {
+			desiredApp, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+			Expect(desiredApp.AllowSSH).To(BeTrue())
+			Expect(lossy.Fields()).To(Equal([]string{"allow_ssh", "environment"}))
+		})
+	})
+
+	It("reports ports and file descriptors matching the recipe defaults", func() {
+		desiredLRP.Ports = []uint32{8080}
+		limits := &models.ResourceLimits{}
+		limits.SetNofile(1024)
+		desiredLRP.Action.GetCodependentAction().Actions[0].GetRunAction().ResourceLimits = limits
+
+		desiredApp, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+		Expect(desiredApp.Ports).To(Equal([]uint32{8080}))
+		Expect(desiredApp.FileDescriptors).To(BeEquivalentTo(1024))
+		Expect(lossy.Fields()).To(Equal([]string{"environment", "ports", "file_descriptors"}))
+	})
+
+	Describe("round-tripping a desire app request through the recipebuilder", func() {
+		var builder *recipebuilder.DesiredLRPBuilder
+
+		BeforeEach(func() {
+			builder = recipebuilder.NewDesiredLRPBuilder(recipebuilder.Config{
+				Lifecycles:    flags.LifecycleMap{"buildpack/some-stack": "lifecycle.tgz"},
+				RootFSes:      map[string]string{"some-stack": "preloaded:some-stack"},
+				FileServerURL: "http://file-server.com",
+			})
+		})
+
+		It("recovers the request and reports the defaults the recipe filled in", func() {
+			routingInfo, err := cc_messages.CCHTTPRoutes{{Hostname: "route1"}}.CCRouteInfo()
+			Expect(err).NotTo(HaveOccurred())
+
+			desiredApp := cc_messages.DesireAppRequestFromCC{
+				ProcessGuid:                 "process-guid",
+				DropletUri:                  "http://droplet.uri",
+				DropletHash:                 "droplet-hash",
+				Stack:                       "some-stack",
+				StartCommand:                "start",
+				ExecutionMetadata:           "{}",
+				Environment:                 []*models.EnvironmentVariable{{Name: "FOO", Value: "BAR"}},
+				MemoryMB:                    128,
+				DiskMB:                      512,
+				NumInstances:                3,
+				RoutingInfo:                 routingInfo,
+				AllowSSH:                    true,
+				LogGuid:                     "log-guid",
+				LogSource:                   "APP",
+				HealthCheckType:             cc_messages.PortHealthCheckType,
+				HealthCheckTimeoutInSeconds: 60,
+				ETag:                        "etag",
+			}
+
+			desiredLRP, err := builder.Build(&desiredApp)
+			Expect(err).NotTo(HaveOccurred())
+
+			reversed, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+			Expect(lossy.Fields()).To(Equal([]string{"environment", "ports", "file_descriptors"}))
+			Expect(reversed.AllowSSH).To(BeTrue())
+			Expect(reversed.Ports).To(Equal([]uint32{8080}))
+			Expect(reversed.FileDescriptors).To(BeEquivalentTo(1024))
+
+			reversed.Ports = nil
+			reversed.FileDescriptors = 0
+			Expect(reversed).To(Equal(desiredApp))
+		})
+	})
+
+	It("falls back to the preloaded rootfs for the stack", func() {
+		desiredLRP.CachedDependencies = nil
+
+		desiredApp, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+		Expect(lossy.Fields()).To(Equal([]string{"environment"}))
+		Expect(desiredApp.Stack).To(Equal("some-stack"))
+	})
+
+	It("reads docker images from the rootfs", func() {
+		desiredLRP.RootFs = "docker:///user/repo#tag"
+		desiredLRP.ImageUsername = "user"
+		desiredLRP.ImagePassword = "password"
+		desiredLRP.Setup = nil
+
+		desiredApp, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+		Expect(desiredApp.DockerImageUrl).To(Equal("docker:///user/repo#tag"))
+		Expect(desiredApp.DockerUser).To(Equal("user"))
+		Expect(desiredApp.DockerPassword).To(Equal("password"))
+		Expect(desiredApp.DropletUri).To(BeEmpty())
+		Expect(desiredApp.Stack).To(BeEmpty())
+		Expect(lossy.Fields()).To(Equal([]string{"docker_login_server", "docker_email", "environment"}))
+	})
+
+	It("reads sealed image passwords back into sealed_docker_password", func() {
+		keyring, err := cc_messages.NewAESGCMKeyring("key-1", map[string][]byte{"key-1": make([]byte, 32)})
+		Expect(err).NotTo(HaveOccurred())
+		sealed, err := keyring.Seal([]byte("password"))
+		Expect(err).NotTo(HaveOccurred())
+
+		desiredLRP.RootFs = "docker:///user/repo#tag"
+		desiredLRP.ImageUsername = "user"
+		desiredLRP.ImagePassword, err = sealed.Encode()
+		Expect(err).NotTo(HaveOccurred())
+		desiredLRP.Setup = nil
+
+		desiredApp, _ := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+		Expect(desiredApp.DockerPassword).To(BeEmpty())
+		Expect(desiredApp.SealedDockerPassword).To(Equal(sealed))
+	})
+
+	Describe("lossy fields", func() {
+		It("reports fields in shapes the recipe never produces", func() {
+			desiredLRP.StartTimeoutMs = 1500
+			desiredLRP.PlacementTags = []string{"segment", "other"}
+			desiredLRP.RootFs = "/var/rootfs"
+			desiredLRP.CachedDependencies = nil
+			desiredLRP.Setup = nil
+			desiredLRP.Action = models.WrapAction(&models.RunAction{Path: "/bin/sh"})
+			desiredLRP.Monitor = models.WrapAction(&models.RunAction{Path: "/bin/true"})
+			desiredLRP.VolumeMounts = []*models.VolumeMount{
+				{Driver: "nfs", Shared: &models.SharedDevice{VolumeId: "volume-id", MountConfig: "{"}},
+			}
+
+			_, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+			Expect(lossy.Fields()).To(Equal([]string{
+				"health_check_timeout_in_seconds",
+				"isolation_segment",
+				"stack",
+				"droplet_uri",
+				"start_command",
+				"execution_metadata",
+				"environment",
+				"health_check_type",
+				"volume_mounts[0].device.mount_config",
+			}))
+		})
+
+		It("reports checksums the CC cannot express", func() {
+			desiredLRP.Setup = models.WrapAction(models.Serial(&models.DownloadAction{
+				From:              "http://droplet.uri",
+				ChecksumAlgorithm: "sha256",
+				ChecksumValue:     "droplet-sha256",
+			}))
+
+			desiredApp, lossy := cc_messages.DesireAppRequestFromDesiredLRP(desiredLRP)
+			Expect(desiredApp.DropletHash).To(BeEmpty())
+			Expect(lossy).To(ContainElement(cc_messages.LossyField{
+				Field: "droplet_hash", Reason: `unsupported checksum algorithm "sha256"`,
+			}))
+			Expect(lossy.String()).To(ContainSubstring(`droplet_hash: unsupported checksum algorithm "sha256"`))
+		})
+	})
+})
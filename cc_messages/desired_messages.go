@@ -17,22 +17,12 @@ const CC_HTTP_ROUTES = "http_routes"
 
 const CC_TCP_ROUTES = "tcp_routes"
 
-const (
-	TaskStatePending   = "PENDING"
-	TaskStateRunning   = "RUNNING"
-	TaskStateCanceling = "CANCELING"
-	TaskStateSucceeded = "SUCCEEDED"
-)
-
 type DesireAppRequestFromCC struct {
-	ProcessGuid                 string                        `json:"process_guid"`
-	DropletUri                  string                        `json:"droplet_uri"`
-	DropletHash                 string                        `json:"droplet_hash"`
-	DockerImageUrl              string                        `json:"docker_image"`
-	DockerLoginServer           string                        `json:"docker_login_server,omitempty"`
-	DockerUser                  string                        `json:"docker_user,omitempty"`
-	DockerPassword              string                        `json:"docker_password,omitempty"`
-	DockerEmail                 string                        `json:"docker_email,omitempty"`
+	ProcessGuid    string `json:"process_guid"`
+	DropletUri     string `json:"droplet_uri"`
+	DropletHash    string `json:"droplet_hash"`
+	DockerImageUrl string `json:"docker_image"`
+	RegistryCredentials
 	Stack                       string                        `json:"stack"`
 	StartCommand                string                        `json:"start_command"`
 	ExecutionMetadata           string                        `json:"execution_metadata"`
@@ -56,6 +46,39 @@ type DesireAppRequestFromCC struct {
 	IsolationSegment            string                        `json:"isolation_segment"`
 }
 
+func (d DesireAppRequestFromCC) Validate() error {
+	var validationError ValidationError
+
+	if d.ProcessGuid == "" {
+		validationError = validationError.Append("process_guid", "cannot be empty")
+	}
+
+	if d.NumInstances < 0 {
+		validationError = validationError.Append("num_instances", "cannot be negative")
+	}
+
+	if d.MemoryMB <= 0 {
+		validationError = validationError.Append("memory_mb", "must be greater than zero")
+	}
+
+	if d.DiskMB <= 0 {
+		validationError = validationError.Append("disk_mb", "must be greater than zero")
+	}
+
+	if d.DockerImageUrl != "" && d.DropletUri != "" {
+		validationError = validationError.Append("docker_image", "cannot be set together with droplet_uri")
+	}
+
+	validationError = validateDockerImage(validationError, "docker_image", d.DockerImageUrl)
+	validationError = append(validationError, d.RegistryCredentials.validate()...)
+
+	if d.HealthCheckHTTPEndpoint != "" && d.HealthCheckType != HTTPHealthCheckType {
+		validationError = validationError.Append("health_check_http_endpoint", "requires health_check_type \"http\"")
+	}
+
+	return validationError.ToError()
+}
+
 type CCRouteInfo map[string]*json.RawMessage
 
 type CCHTTPRoutes []CCHTTPRoute
@@ -122,9 +145,9 @@ type CCDesiredAppFingerprint struct {
 }
 
 type CCTaskState struct {
-	TaskGuid              string `json:"task_guid"`
-	State                 string `json:"state"`
-	CompletionCallbackUrl string `json:"completion_callback"`
+	TaskGuid              string    `json:"task_guid"`
+	State                 TaskState `json:"state"`
+	CompletionCallbackUrl string    `json:"completion_callback"`
 }
 
 type CCDesiredStateFingerprintResponse struct {
@@ -137,31 +160,65 @@ type CCTaskStatesResponse struct {
 	CCBulkToken *json.RawMessage `json:"token"`
 }
 
-type CCBulkToken struct {
-	Id int `json:"id"`
+// NextToken returns the cursor of the page following this one; a nil or
+// JSON null token ends the listing.
+func (r CCDesiredStateServerResponse) NextToken() *json.RawMessage {
+	return r.CCBulkToken
+}
+
+func (r CCDesiredStateFingerprintResponse) NextToken() *json.RawMessage {
+	return r.CCBulkToken
+}
+
+func (r CCTaskStatesResponse) NextToken() *json.RawMessage {
+	return r.CCBulkToken
 }
 
 type TaskErrorID string
 
+const INVALID_TASK_REQUEST TaskErrorID = "InvalidTaskRequest"
+
 type TaskRequestFromCC struct {
-	TaskGuid              string                        `json:"task_guid"`
-	LogGuid               string                        `json:"log_guid"`
-	MemoryMb              int                           `json:"memory_mb"`
-	DiskMb                int                           `json:"disk_mb"`
-	Lifecycle             string                        `json:"lifecycle"`
-	EnvironmentVariables  []*models.EnvironmentVariable `json:"environment"`
-	EgressRules           []*models.SecurityGroupRule   `json:"egress_rules,omitempty"`
-	DropletUri            string                        `json:"droplet_uri"`
-	DropletHash           string                        `json:"droplet_hash"`
-	DockerPath            string                        `json:"docker_path"`
-	DockerUser            string                        `json:"docker_user,omitempty"`
-	DockerPassword        string                        `json:"docker_password,omitempty"`
-	RootFs                string                        `json:"rootfs"`
-	CompletionCallbackUrl string                        `json:"completion_callback"`
-	Command               string                        `json:"command"`
-	LogSource             string                        `json:"log_source,omit_empty"`
-	VolumeMounts          []*VolumeMount                `json:"volume_mounts"`
-	IsolationSegment      string                        `json:"isolation_segment"`
+	TaskGuid             string                        `json:"task_guid"`
+	LogGuid              string                        `json:"log_guid"`
+	MemoryMb             int                           `json:"memory_mb"`
+	DiskMb               int                           `json:"disk_mb"`
+	Lifecycle            string                        `json:"lifecycle"`
+	EnvironmentVariables []*models.EnvironmentVariable `json:"environment"`
+	EgressRules          []*models.SecurityGroupRule   `json:"egress_rules,omitempty"`
+	DropletUri           string                        `json:"droplet_uri"`
+	DropletHash          string                        `json:"droplet_hash"`
+	DockerPath           string                        `json:"docker_path"`
+	RegistryCredentials
+	RootFs                string         `json:"rootfs"`
+	CompletionCallbackUrl string         `json:"completion_callback"`
+	Command               string         `json:"command"`
+	LogSource             string         `json:"log_source,omit_empty"`
+	VolumeMounts          []*VolumeMount `json:"volume_mounts"`
+	IsolationSegment      string         `json:"isolation_segment"`
+}
+
+func (t TaskRequestFromCC) Validate() error {
+	return DefaultLifecycleRegistry.ValidateTaskRequest(t)
+}
+
+// NewTaskValidationError converts a TaskRequestFromCC validation failure
+// into the error body returned to the CC when the task is rejected.
+func NewTaskValidationError(err ValidationError) TaskError {
+	return TaskError{
+		Id:      INVALID_TASK_REQUEST,
+		Message: err.Error(),
+	}
+}
+
+// NewTaskValidationFailResponse converts a TaskRequestFromCC validation
+// failure into the completion callback payload for a failed task.
+func NewTaskValidationFailResponse(taskGuid string, err ValidationError) TaskFailResponseForCC {
+	return TaskFailResponseForCC{
+		TaskGuid:      taskGuid,
+		Failed:        true,
+		FailureReason: err.Error(),
+	}
 }
 
 type TaskFailResponseForCC struct {
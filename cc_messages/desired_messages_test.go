@@ -26,4 +26,154 @@ var _ = Describe("CC Messages", func() {
 			Expect(string(*json)).To(MatchJSON(expectedJson))
 		})
 	})
+
+	Describe("DesireAppRequestFromCC", func() {
+		Describe("Validate", func() {
+			var desireAppRequest cc_messages.DesireAppRequestFromCC
+
+			BeforeEach(func() {
+				desireAppRequest = cc_messages.DesireAppRequestFromCC{
+					ProcessGuid:  "process-guid",
+					DropletUri:   "http://example.com/droplet",
+					NumInstances: 2,
+					MemoryMB:     128,
+					DiskMB:       512,
+				}
+			})
+
+			It("is valid", func() {
+				Expect(desireAppRequest.Validate()).To(Succeed())
+			})
+
+			It("is valid with no instances", func() {
+				desireAppRequest.NumInstances = 0
+				Expect(desireAppRequest.Validate()).To(Succeed())
+			})
+
+			It("is valid with an http health check endpoint", func() {
+				desireAppRequest.HealthCheckType = cc_messages.HTTPHealthCheckType
+				desireAppRequest.HealthCheckHTTPEndpoint = "/health"
+				Expect(desireAppRequest.Validate()).To(Succeed())
+			})
+
+			It("reports every invalid field in a single error", func() {
+				desireAppRequest = cc_messages.DesireAppRequestFromCC{
+					DropletUri:              "http://example.com/droplet",
+					DockerImageUrl:          "docker:///diego/image",
+					NumInstances:            -1,
+					HealthCheckType:         cc_messages.PortHealthCheckType,
+					HealthCheckHTTPEndpoint: "/health",
+				}
+
+				err := desireAppRequest.Validate()
+				Expect(err).To(HaveOccurred())
+
+				validationError, ok := err.(cc_messages.ValidationError)
+				Expect(ok).To(BeTrue())
+				Expect(validationError.Paths()).To(Equal([]string{
+					"process_guid",
+					"num_instances",
+					"memory_mb",
+					"disk_mb",
+					"docker_image",
+					"health_check_http_endpoint",
+				}))
+			})
+
+			It("describes the invalid fields in the error message", func() {
+				desireAppRequest.ProcessGuid = ""
+				desireAppRequest.DiskMB = 0
+
+				err := desireAppRequest.Validate()
+				Expect(err).To(MatchError("Invalid fields: process_guid: cannot be empty, disk_mb: must be greater than zero"))
+			})
+		})
+	})
+
+	Describe("TaskRequestFromCC", func() {
+		Describe("Validate", func() {
+			var taskRequest cc_messages.TaskRequestFromCC
+
+			BeforeEach(func() {
+				taskRequest = cc_messages.TaskRequestFromCC{
+					TaskGuid:              "task-guid",
+					Lifecycle:             cc_messages.BUILDPACK_LIFECYCLE,
+					DropletUri:            "http://example.com/droplet",
+					DropletHash:           "droplet-hash",
+					CompletionCallbackUrl: "https://api.cc.com/tasks/complete",
+				}
+			})
+
+			It("is valid for the buildpack lifecycle", func() {
+				Expect(taskRequest.Validate()).To(Succeed())
+			})
+
+			It("is valid for the docker lifecycle", func() {
+				taskRequest = cc_messages.TaskRequestFromCC{
+					Lifecycle:  cc_messages.DOCKER_LIFECYCLE,
+					DockerPath: "docker:///diego/image",
+					RegistryCredentials: cc_messages.RegistryCredentials{
+						DockerUser:     "user",
+						DockerPassword: "password",
+					},
+				}
+				Expect(taskRequest.Validate()).To(Succeed())
+			})
+
+			It("rejects an unknown lifecycle", func() {
+				taskRequest.Lifecycle = "windows"
+
+				err := taskRequest.Validate()
+				Expect(err).To(BeAssignableToTypeOf(cc_messages.ValidationError{}))
+				Expect(err.(cc_messages.ValidationError).Paths()).To(Equal([]string{"lifecycle"}))
+			})
+
+			It("requires buildpack fields and rejects docker fields for the buildpack lifecycle", func() {
+				taskRequest.DropletUri = ""
+				taskRequest.DropletHash = ""
+				taskRequest.DockerPath = "docker:///diego/image"
+				taskRequest.DockerUser = "user"
+				taskRequest.DockerPassword = "password"
+
+				err := taskRequest.Validate()
+				Expect(err).To(BeAssignableToTypeOf(cc_messages.ValidationError{}))
+				Expect(err.(cc_messages.ValidationError).Paths()).To(Equal([]string{
+					"droplet_uri", "droplet_hash", "docker_path", "docker_user", "docker_password",
+				}))
+			})
+
+			It("requires docker fields and rejects buildpack fields for the docker lifecycle", func() {
+				taskRequest.Lifecycle = cc_messages.DOCKER_LIFECYCLE
+				taskRequest.DockerPassword = "password"
+
+				err := taskRequest.Validate()
+				Expect(err).To(BeAssignableToTypeOf(cc_messages.ValidationError{}))
+				Expect(err.(cc_messages.ValidationError).Paths()).To(Equal([]string{
+					"docker_path", "docker_user", "droplet_uri", "droplet_hash",
+				}))
+			})
+
+			It("requires the completion callback to be an absolute URL", func() {
+				taskRequest.CompletionCallbackUrl = "/tasks/complete"
+
+				err := taskRequest.Validate()
+				Expect(err).To(MatchError("Invalid fields: completion_callback: must be an absolute URL"))
+			})
+
+			It("maps the error onto the CC task error shapes", func() {
+				taskRequest.Lifecycle = ""
+				validationError := taskRequest.Validate().(cc_messages.ValidationError)
+
+				Expect(cc_messages.NewTaskValidationError(validationError)).To(Equal(cc_messages.TaskError{
+					Id:      cc_messages.INVALID_TASK_REQUEST,
+					Message: validationError.Error(),
+				}))
+				Expect(cc_messages.NewTaskValidationFailResponse("task-guid", validationError)).To(Equal(cc_messages.TaskFailResponseForCC{
+					TaskGuid:      "task-guid",
+					Failed:        true,
+					FailureReason: validationError.Error(),
+				}))
+			})
+		})
+	})
 })
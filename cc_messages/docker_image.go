@@ -0,0 +1,300 @@
+package cc_messages
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	DockerImageScheme = "docker"
+
+	// DockerHubRegistry is the registry of images given without one.
+	DockerHubRegistry = "docker.io"
+
+	// DockerHubLoginServer is the key docker uses for Docker Hub
+	// credentials.
+	DockerHubLoginServer = "https://index.docker.io/v1/"
+
+	dockerHubOfficialNamespace = "library"
+	dockerDefaultTag           = "latest"
+	maxDockerRepositoryLength  = 255
+)
+
+var ErrDockerImageScheme = errors.New("docker image must not contain a scheme other than docker://")
+
+type InvalidDockerImageError struct {
+	Image  string
+	Reason string
+}
+
+func (e InvalidDockerImageError) Error() string {
+	return fmt.Sprintf("invalid docker image %q: %s", e.Image, e.Reason)
+}
+
+var dockerHubAliases = map[string]bool{
+	DockerHubRegistry:         true,
+	"index.docker.io":         true,
+	"registry-1.docker.io":    true,
+	"registry.hub.docker.com": true,
+}
+
+var (
+	dockerRegistryPattern   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*(:[0-9]+)?$`)
+	dockerComponentPattern  = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*$`)
+	dockerTagPattern        = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+	dockerDigestPattern     = regexp.MustCompile(`^[a-z0-9]+([+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+	dockerDigestHexPatterns = map[string]*regexp.Regexp{
+		"sha256": regexp.MustCompile(`^[a-f0-9]{64}$`),
+		"sha512": regexp.MustCompile(`^[a-f0-9]{128}$`),
+	}
+)
+
+// DockerImageReference is a parsed docker image. Registry is always set,
+// DockerHubRegistry for Docker Hub, and Docker Hub official images carry
+// their "library/" namespace in Repository. Tag and Digest are empty when
+// not given.
+type DockerImageReference struct {
+	// Scheme is DockerImageScheme when the image was given as a docker://
+	// URI and empty otherwise.
+	Scheme     string
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseDockerImageReference parses and validates an image given either as a
+// docker:// URI, such as "docker:///diego/image#tag" or
+// "docker://registry.example.com/repo#sha256:...", or in the plain
+// "registry/repo:tag@digest" form.
+func ParseDockerImageReference(image string) (DockerImageReference, error) {
+	var ref DockerImageReference
+	var err error
+	if strings.Contains(image, "://") {
+		ref, err = parseDockerImageURI(image)
+	} else {
+		ref, err = parsePlainDockerImage(image)
+	}
+	if err != nil {
+		return DockerImageReference{}, err
+	}
+
+	if dockerHubAliases[strings.ToLower(ref.Registry)] || ref.Registry == "" {
+		ref.Registry = DockerHubRegistry
+		if ref.Repository != "" && !strings.Contains(ref.Repository, "/") {
+			ref.Repository = dockerHubOfficialNamespace + "/" + ref.Repository
+		}
+	}
+
+	reason := ref.validate()
+	if reason != "" {
+		return DockerImageReference{}, InvalidDockerImageError{Image: image, Reason: reason}
+	}
+	return ref, nil
+}
+
+func parseDockerImageURI(image string) (DockerImageReference, error) {
+	u, err := url.Parse(image)
+	if err != nil {
+		return DockerImageReference{}, InvalidDockerImageError{Image: image, Reason: err.Error()}
+	}
+	if u.Scheme != DockerImageScheme {
+		return DockerImageReference{}, ErrDockerImageScheme
+	}
+	if u.User != nil || u.RawQuery != "" || u.Opaque != "" {
+		return DockerImageReference{}, InvalidDockerImageError{Image: image, Reason: "must only contain a registry, repository and tag or digest"}
+	}
+
+	ref := DockerImageReference{
+		Scheme:     DockerImageScheme,
+		Registry:   u.Host,
+		Repository: strings.TrimPrefix(u.Path, "/"),
+	}
+	if strings.Contains(u.Fragment, ":") {
+		ref.Digest = u.Fragment
+	} else {
+		ref.Tag = u.Fragment
+	}
+	return ref, nil
+}
+
+func parsePlainDockerImage(image string) (DockerImageReference, error) {
+	var ref DockerImageReference
+
+	remainder := image
+	if i := strings.Index(remainder, "@"); i >= 0 {
+		remainder, ref.Digest = remainder[:i], remainder[i+1:]
+		if ref.Digest == "" {
+			return DockerImageReference{}, InvalidDockerImageError{Image: image, Reason: "digest cannot be empty"}
+		}
+	}
+
+	parts := strings.SplitN(remainder, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry, remainder = parts[0], parts[1]
+	}
+
+	if i := strings.LastIndex(remainder, ":"); i > strings.LastIndex(remainder, "/") {
+		remainder, ref.Tag = remainder[:i], remainder[i+1:]
+		if ref.Tag == "" {
+			return DockerImageReference{}, InvalidDockerImageError{Image: image, Reason: "tag cannot be empty"}
+		}
+	}
+
+	ref.Repository = remainder
+	return ref, nil
+}
+
+func (r DockerImageReference) validate() string {
+	if !dockerRegistryPattern.MatchString(r.Registry) {
+		return fmt.Sprintf("invalid registry %q", r.Registry)
+	}
+
+	if r.Repository == "" {
+		return "repository cannot be empty"
+	}
+	if len(r.Repository) > maxDockerRepositoryLength {
+		return fmt.Sprintf("repository cannot be longer than %d characters", maxDockerRepositoryLength)
+	}
+	for _, component := range strings.Split(r.Repository, "/") {
+		if !dockerComponentPattern.MatchString(component) {
+			return fmt.Sprintf("invalid repository %q", r.Repository)
+		}
+	}
+
+	if r.Tag != "" && !dockerTagPattern.MatchString(r.Tag) {
+		return fmt.Sprintf("invalid tag %q", r.Tag)
+	}
+
+	if r.Digest != "" {
+		if !dockerDigestPattern.MatchString(r.Digest) {
+			return fmt.Sprintf("invalid digest %q", r.Digest)
+		}
+		parts := strings.SplitN(r.Digest, ":", 2)
+		if pattern, ok := dockerDigestHexPatterns[parts[0]]; ok && !pattern.MatchString(parts[1]) {
+			return fmt.Sprintf("invalid %s digest %q", parts[0], r.Digest)
+		}
+	}
+
+	return ""
+}
+
+func (r DockerImageReference) IsDockerHub() bool {
+	return r.Registry == DockerHubRegistry
+}
+
+// Name is the fully qualified repository, e.g. "docker.io/library/ubuntu".
+func (r DockerImageReference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// Familiar returns the plain form users write: Docker Hub images without
+// their registry or "library/" namespace, and the tag and digest as given.
+func (r DockerImageReference) Familiar() string {
+	name := r.Name()
+	if r.IsDockerHub() {
+		name = strings.TrimPrefix(r.Repository, dockerHubOfficialNamespace+"/")
+	}
+	return name + r.suffix(false)
+}
+
+// OCI returns the fully qualified form, e.g.
+// "docker.io/library/ubuntu:latest". The tag defaults to "latest" when
+// neither a tag nor a digest is given.
+func (r DockerImageReference) OCI() string {
+	return r.Name() + r.suffix(true)
+}
+
+// URI returns the docker:// rootfs URI garden expects. Docker Hub images
+// have an empty host and the fragment holds the digest if there is one,
+// the tag otherwise, defaulting to "latest".
+func (r DockerImageReference) URI() string {
+	host := r.Registry
+	if r.IsDockerHub() {
+		host = ""
+	}
+
+	fragment := r.Digest
+	if fragment == "" {
+		fragment = r.Tag
+	}
+	if fragment == "" {
+		fragment = dockerDefaultTag
+	}
+
+	return (&url.URL{
+		Scheme:   DockerImageScheme,
+		Host:     host,
+		Path:     "/" + r.Repository,
+		Fragment: fragment,
+	}).String()
+}
+
+func (r DockerImageReference) String() string {
+	return r.Familiar()
+}
+
+func (r DockerImageReference) suffix(defaultTag bool) string {
+	tag := r.Tag
+	if tag == "" && r.Digest == "" && defaultTag {
+		tag = dockerDefaultTag
+	}
+
+	var suffix string
+	if tag != "" {
+		suffix += ":" + tag
+	}
+	if r.Digest != "" {
+		suffix += "@" + r.Digest
+	}
+	return suffix
+}
+
+// ParseDockerRegistry normalizes a login server such as
+// "https://index.docker.io/v1/" or "registry.example.com:5000" to the
+// registry host used by DockerImageReference.
+func ParseDockerRegistry(loginServer string) (string, error) {
+	host := loginServer
+	if i := strings.Index(host, "://"); i >= 0 {
+		scheme := host[:i]
+		if scheme != "http" && scheme != "https" {
+			return "", fmt.Errorf("invalid docker registry %q: unsupported scheme %q", loginServer, scheme)
+		}
+		host = host[i+3:]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+
+	host = strings.ToLower(host)
+	if dockerHubAliases[host] {
+		return DockerHubRegistry, nil
+	}
+	if !dockerRegistryPattern.MatchString(host) {
+		return "", fmt.Errorf("invalid docker registry %q", loginServer)
+	}
+	return host, nil
+}
+
+func validateDockerImage(validationError ValidationError, path, image string) ValidationError {
+	if image == "" {
+		return validationError
+	}
+	if _, err := ParseDockerImageReference(image); err != nil {
+		return validationError.Append(path, err.Error())
+	}
+	return validationError
+}
+
+func validateDockerLoginServer(validationError ValidationError, loginServer string) ValidationError {
+	if loginServer == "" {
+		return validationError
+	}
+	if _, err := ParseDockerRegistry(loginServer); err != nil {
+		return validationError.Append("docker_login_server", err.Error())
+	}
+	return validationError
+}
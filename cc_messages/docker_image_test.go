@@ -0,0 +1,146 @@
+package cc_messages_test
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DockerImageReference", func() {
+	digest := "sha256:" + strings.Repeat("0123456789abcdef", 4)
+
+	DescribeTable("parsing",
+		func(image string, expected cc_messages.DockerImageReference) {
+			ref, err := cc_messages.ParseDockerImageReference(image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref).To(Equal(expected))
+		},
+		Entry("an official image", "ubuntu",
+			cc_messages.DockerImageReference{Registry: "docker.io", Repository: "library/ubuntu"}),
+		Entry("a user image with a tag", "user/repo:tag",
+			cc_messages.DockerImageReference{Registry: "docker.io", Repository: "user/repo", Tag: "tag"}),
+		Entry("an explicit docker hub registry", "index.docker.io/ubuntu:20.04",
+			cc_messages.DockerImageReference{Registry: "docker.io", Repository: "library/ubuntu", Tag: "20.04"}),
+		Entry("a private registry with a port", "registry.example.com:5000/team/repo:v1",
+			cc_messages.DockerImageReference{Registry: "registry.example.com:5000", Repository: "team/repo", Tag: "v1"}),
+		Entry("localhost", "localhost/repo",
+			cc_messages.DockerImageReference{Registry: "localhost", Repository: "repo"}),
+		Entry("a tag and digest", "user/repo:tag@"+digest,
+			cc_messages.DockerImageReference{Registry: "docker.io", Repository: "user/repo", Tag: "tag", Digest: digest}),
+		Entry("a docker uri", "docker:///diego/image",
+			cc_messages.DockerImageReference{Scheme: "docker", Registry: "docker.io", Repository: "diego/image"}),
+		Entry("a docker uri with a tag", "docker:///ubuntu#20.04",
+			cc_messages.DockerImageReference{Scheme: "docker", Registry: "docker.io", Repository: "library/ubuntu", Tag: "20.04"}),
+		Entry("a docker uri with a registry and digest", "docker://registry.example.com/repo#"+digest,
+			cc_messages.DockerImageReference{Scheme: "docker", Registry: "registry.example.com", Repository: "repo", Digest: digest}),
+	)
+
+	DescribeTable("rejecting malformed images",
+		func(image, reason string) {
+			_, err := cc_messages.ParseDockerImageReference(image)
+			Expect(err).To(MatchError(ContainSubstring(reason)))
+		},
+		Entry("another scheme", "https://example.com/image", "scheme other than docker://"),
+		Entry("an empty image", "", "repository cannot be empty"),
+		Entry("uppercase repositories", "user/Repo", "invalid repository"),
+		Entry("an empty tag", "user/repo:", "tag cannot be empty"),
+		Entry("an invalid tag", "user/repo:-tag", "invalid tag"),
+		Entry("an empty digest", "user/repo@", "digest cannot be empty"),
+		Entry("a short sha256 digest", "user/repo@sha256:abc", "invalid sha256 digest"),
+		Entry("an invalid digest", "user/repo@nope", "invalid digest"),
+		Entry("an invalid registry", "docker://bad_host/repo", "invalid registry"),
+		Entry("a docker uri with a query", "docker:///repo?x=y", "must only contain"),
+		Entry("a long repository", "user/"+strings.Repeat("a", 256), "longer than 255"),
+	)
+
+	Describe("converting between forms", func() {
+		It("converts plain images", func() {
+			ref, err := cc_messages.ParseDockerImageReference("ubuntu")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ref.Familiar()).To(Equal("ubuntu"))
+			Expect(ref.String()).To(Equal("ubuntu"))
+			Expect(ref.OCI()).To(Equal("docker.io/library/ubuntu:latest"))
+			Expect(ref.URI()).To(Equal("docker:///library/ubuntu#latest"))
+			Expect(ref.Name()).To(Equal("docker.io/library/ubuntu"))
+		})
+
+		It("converts images from private registries", func() {
+			ref, err := cc_messages.ParseDockerImageReference("docker://registry.example.com:5000/team/repo#v1")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ref.IsDockerHub()).To(BeFalse())
+			Expect(ref.Familiar()).To(Equal("registry.example.com:5000/team/repo:v1"))
+			Expect(ref.OCI()).To(Equal("registry.example.com:5000/team/repo:v1"))
+			Expect(ref.URI()).To(Equal("docker://registry.example.com:5000/team/repo#v1"))
+		})
+
+		It("prefers the digest over the tag in the uri", func() {
+			ref, err := cc_messages.ParseDockerImageReference("user/repo:tag@" + digest)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ref.Familiar()).To(Equal("user/repo:tag@" + digest))
+			Expect(ref.OCI()).To(Equal("docker.io/user/repo:tag@" + digest))
+			Expect(ref.URI()).To(Equal("docker:///user/repo#" + digest))
+		})
+
+		It("round-trips through every form", func() {
+			ref, err := cc_messages.ParseDockerImageReference("registry.example.com/team/repo:v1")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, form := range []string{ref.Familiar(), ref.OCI(), ref.URI()} {
+				parsed, err := cc_messages.ParseDockerImageReference(form)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(parsed.OCI()).To(Equal(ref.OCI()), form)
+			}
+		})
+	})
+
+	DescribeTable("ParseDockerRegistry",
+		func(loginServer, expected string) {
+			registry, err := cc_messages.ParseDockerRegistry(loginServer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(registry).To(Equal(expected))
+		},
+		Entry("the docker hub login server", cc_messages.DockerHubLoginServer, "docker.io"),
+		Entry("a bare host", "registry.example.com:5000", "registry.example.com:5000"),
+		Entry("a url with a path", "https://Registry.Example.com/v2/", "registry.example.com"),
+	)
+
+	It("rejects invalid login servers", func() {
+		_, err := cc_messages.ParseDockerRegistry("ftp://registry.example.com")
+		Expect(err).To(MatchError(ContainSubstring("unsupported scheme")))
+
+		_, err = cc_messages.ParseDockerRegistry("bad_host")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("message validation", func() {
+		It("rejects malformed images when the app is desired", func() {
+			err := cc_messages.DesireAppRequestFromCC{
+				ProcessGuid:    "process-guid",
+				MemoryMB:       128,
+				DiskMB:         512,
+				DockerImageUrl: "user/Repo",
+				RegistryCredentials: cc_messages.RegistryCredentials{
+					DockerLoginServer: "bad_host",
+				},
+			}.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(cc_messages.ValidationError).Paths()).To(Equal([]string{"docker_image", "docker_login_server"}))
+		})
+
+		It("rejects malformed task images", func() {
+			err := cc_messages.TaskRequestFromCC{
+				TaskGuid:   "task-guid",
+				Lifecycle:  cc_messages.DOCKER_LIFECYCLE,
+				DockerPath: "user/repo:-bad",
+			}.Validate()
+			Expect(err).To(MatchError(ContainSubstring("docker_path")))
+		})
+	})
+})
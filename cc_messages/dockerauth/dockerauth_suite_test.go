@@ -0,0 +1,13 @@
+package dockerauth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDockerauth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dockerauth Suite")
+}
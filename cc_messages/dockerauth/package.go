@@ -0,0 +1 @@
+package dockerauth // import "code.cloudfoundry.org/runtimeschema/cc_messages/dockerauth"
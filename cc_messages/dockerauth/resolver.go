@@ -0,0 +1,321 @@
+package dockerauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+const (
+	ConfigFileName = "config.json"
+
+	// CredentialHelperPrefix prefixes the name of every credential helper
+	// binary, e.g. "docker-credential-desktop".
+	CredentialHelperPrefix = "docker-credential-"
+
+	// identityTokenUsername is the username credential helpers return for
+	// identity tokens.
+	identityTokenUsername = "<token>"
+)
+
+var ErrIdentityTokenUnsupported = errors.New("docker identity tokens are not supported; use a username and password")
+
+// Config is the subset of a docker config.json that holds registry
+// credentials.
+type Config struct {
+	Auths       map[string]AuthConfig `json:"auths,omitempty"`
+	CredsStore  string                `json:"credsStore,omitempty"`
+	CredHelpers map[string]string     `json:"credHelpers,omitempty"`
+}
+
+type AuthConfig struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Email         string `json:"email,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// CredentialHelper looks up the credentials stored for serverURL by the
+// named credential helper. It returns empty credentials when the helper has
+// none.
+type CredentialHelper func(helper, serverURL string) (username, secret string, err error)
+
+// LoadConfig reads a docker config.json. A missing file is an empty config.
+func LoadConfig(path string) (*Config, error) {
+	payload, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	err = json.Unmarshal(payload, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker config %s: %s", path, err)
+	}
+	return config, nil
+}
+
+// DefaultConfigPath is the config.json under $DOCKER_CONFIG, or under
+// ~/.docker when it is unset.
+func DefaultConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, ConfigFileName), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", ConfigFileName), nil
+}
+
+// Resolver finds the registry credentials for docker images the way the
+// docker CLI does: a registry's credential helper is preferred over the
+// credential store, which is preferred over the auths of the config file.
+type Resolver struct {
+	config *Config
+	helper CredentialHelper
+}
+
+type ResolverOption func(*Resolver)
+
+// WithCredentialHelper replaces running the docker-credential-* binaries.
+func WithCredentialHelper(helper CredentialHelper) ResolverOption {
+	return func(r *Resolver) {
+		r.helper = helper
+	}
+}
+
+func NewResolver(config *Config, options ...ResolverOption) *Resolver {
+	if config == nil {
+		config = &Config{}
+	}
+
+	r := &Resolver{config: config, helper: execCredentialHelper}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// NewDefaultResolver resolves with the config at DefaultConfigPath.
+func NewDefaultResolver(options ...ResolverOption) (*Resolver, error) {
+	path, err := DefaultConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(config, options...), nil
+}
+
+// Resolve returns the credentials for the registry of image. Images whose
+// registry has no credentials get empty credentials.
+func (r *Resolver) Resolve(image string) (cc_messages.RegistryCredentials, error) {
+	ref, err := cc_messages.ParseDockerImageReference(image)
+	if err != nil {
+		return cc_messages.RegistryCredentials{}, err
+	}
+	return r.ResolveRegistry(ref.Registry)
+}
+
+// ResolveRegistry returns the credentials for a registry host or login
+// server. DockerLoginServer is only set for registries other than Docker
+// Hub.
+func (r *Resolver) ResolveRegistry(registry string) (cc_messages.RegistryCredentials, error) {
+	registry, err := cc_messages.ParseDockerRegistry(registry)
+	if err != nil {
+		return cc_messages.RegistryCredentials{}, err
+	}
+
+	var credentials cc_messages.RegistryCredentials
+	if helper := r.helperFor(registry); helper != "" {
+		credentials, err = r.fromHelper(helper, registry)
+	} else {
+		credentials, err = r.fromAuths(registry)
+	}
+	if err != nil || !credentials.HasRegistryCredentials() {
+		return cc_messages.RegistryCredentials{}, err
+	}
+
+	if registry != cc_messages.DockerHubRegistry {
+		credentials.DockerLoginServer = registry
+	}
+	return credentials, nil
+}
+
+// Fill resolves credentials for image into credentials unless they already
+// hold a user or password, so that credentials given in a message always
+// win over local ones.
+func (r *Resolver) Fill(image string, credentials *cc_messages.RegistryCredentials) error {
+	if credentials.DockerUser != "" || credentials.HasDockerPassword() {
+		return nil
+	}
+
+	resolved, err := r.Resolve(image)
+	if err != nil {
+		return err
+	}
+	if !resolved.HasRegistryCredentials() {
+		return nil
+	}
+
+	if credentials.DockerEmail != "" {
+		resolved.DockerEmail = credentials.DockerEmail
+	}
+	*credentials = resolved
+	return nil
+}
+
+func (r *Resolver) FillDesireAppRequest(desireApp *cc_messages.DesireAppRequestFromCC) error {
+	if desireApp.DockerImageUrl == "" {
+		return nil
+	}
+	return r.Fill(desireApp.DockerImageUrl, &desireApp.RegistryCredentials)
+}
+
+func (r *Resolver) FillTaskRequest(task *cc_messages.TaskRequestFromCC) error {
+	if task.DockerPath == "" {
+		return nil
+	}
+	return r.Fill(task.DockerPath, &task.RegistryCredentials)
+}
+
+func (r *Resolver) FillDockerStagingData(data *cc_messages.DockerStagingData) error {
+	return r.Fill(data.DockerImageUrl, &data.RegistryCredentials)
+}
+
+func (r *Resolver) helperFor(registry string) string {
+	for _, server := range sortedHelperServers(r.config.CredHelpers) {
+		if normalized, err := cc_messages.ParseDockerRegistry(server); err == nil && normalized == registry {
+			return r.config.CredHelpers[server]
+		}
+	}
+	return r.config.CredsStore
+}
+
+func (r *Resolver) fromHelper(helper, registry string) (cc_messages.RegistryCredentials, error) {
+	serverURL := registry
+	if registry == cc_messages.DockerHubRegistry {
+		serverURL = cc_messages.DockerHubLoginServer
+	}
+
+	username, secret, err := r.helper(helper, serverURL)
+	if err != nil {
+		return cc_messages.RegistryCredentials{}, fmt.Errorf("docker credential helper %q failed for %s: %s", helper, serverURL, err)
+	}
+	if username == identityTokenUsername {
+		return cc_messages.RegistryCredentials{}, ErrIdentityTokenUnsupported
+	}
+
+	return cc_messages.RegistryCredentials{DockerUser: username, DockerPassword: secret}, nil
+}
+
+func (r *Resolver) fromAuths(registry string) (cc_messages.RegistryCredentials, error) {
+	for _, server := range sortedAuthServers(r.config.Auths) {
+		normalized, err := cc_messages.ParseDockerRegistry(server)
+		if err != nil || normalized != registry {
+			continue
+		}
+
+		auth := r.config.Auths[server]
+		if auth.IdentityToken != "" {
+			return cc_messages.RegistryCredentials{}, ErrIdentityTokenUnsupported
+		}
+
+		credentials := cc_messages.RegistryCredentials{
+			DockerUser:     auth.Username,
+			DockerPassword: auth.Password,
+			DockerEmail:    auth.Email,
+		}
+		if auth.Auth != "" {
+			credentials.DockerUser, credentials.DockerPassword, err = decodeAuth(auth.Auth)
+			if err != nil {
+				return cc_messages.RegistryCredentials{}, fmt.Errorf("invalid auth for %s: %s", server, err)
+			}
+		}
+		return credentials, nil
+	}
+
+	return cc_messages.RegistryCredentials{}, nil
+}
+
+func decodeAuth(auth string) (string, string, error) {
+	// Some tools write auths without padding.
+	decoded, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		decoded, err = base64.RawStdEncoding.DecodeString(auth)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("expected base64 encoded username:password")
+	}
+	return parts[0], parts[1], nil
+}
+
+// execCredentialHelper runs "docker-credential-<helper> get" with serverURL
+// on stdin, following the docker credential helper protocol.
+func execCredentialHelper(helper, serverURL string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(CredentialHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		message := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(message, "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("%s: %s", err, message)
+	}
+
+	var response struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	err = json.Unmarshal(stdout.Bytes(), &response)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid response: %s", err)
+	}
+	return response.Username, response.Secret, nil
+}
+
+func sortedHelperServers(helpers map[string]string) []string {
+	servers := make([]string, 0, len(helpers))
+	for server := range helpers {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+func sortedAuthServers(auths map[string]AuthConfig) []string {
+	servers := make([]string, 0, len(auths))
+	for server := range auths {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	return servers
+}
@@ -0,0 +1,223 @@
+package dockerauth_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/dockerauth"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolver", func() {
+	var (
+		config   *dockerauth.Config
+		lookups  []string
+		resolver *dockerauth.Resolver
+	)
+
+	helper := func(helper, serverURL string) (string, string, error) {
+		lookups = append(lookups, helper+" "+serverURL)
+		switch helper {
+		case "desktop":
+			return "store-user", "store-secret", nil
+		case "ecr-login":
+			return "AWS", "ecr-secret", nil
+		case "token":
+			return "<token>", "identity-token", nil
+		case "broken":
+			return "", "", errors.New("boom")
+		}
+		return "", "", nil
+	}
+
+	BeforeEach(func() {
+		lookups = nil
+		config = &dockerauth.Config{
+			Auths: map[string]dockerauth.AuthConfig{
+				"https://index.docker.io/v1/": {Auth: base64.StdEncoding.EncodeToString([]byte("hub-user:hub:secret"))},
+				"registry.example.com:5000":   {Username: "private-user", Password: "private-secret", Email: "me@example.com"},
+				"https://token.example.com":   {IdentityToken: "identity-token"},
+				"unpadded.example.com":        {Auth: base64.RawStdEncoding.EncodeToString([]byte("raw-user:secret!"))},
+				"bad.example.com":             {Auth: "not base64!"},
+			},
+			CredHelpers: map[string]string{
+				"123.dkr.ecr.us-east-1.amazonaws.com": "ecr-login",
+				"token-helper.example.com":            "token",
+				"broken.example.com":                  "broken",
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		resolver = dockerauth.NewResolver(config, dockerauth.WithCredentialHelper(helper))
+	})
+
+	It("resolves docker hub credentials from the auths", func() {
+		credentials, err := resolver.Resolve("ubuntu")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(Equal(cc_messages.RegistryCredentials{DockerUser: "hub-user", DockerPassword: "hub:secret"}))
+	})
+
+	It("accepts auths without base64 padding", func() {
+		credentials, err := resolver.Resolve("unpadded.example.com/repo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(Equal(cc_messages.RegistryCredentials{
+			DockerLoginServer: "unpadded.example.com",
+			DockerUser:        "raw-user",
+			DockerPassword:    "secret!",
+		}))
+	})
+
+	It("resolves private registry credentials and sets the login server", func() {
+		credentials, err := resolver.Resolve("docker://registry.example.com:5000/team/repo#v1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(Equal(cc_messages.RegistryCredentials{
+			DockerLoginServer: "registry.example.com:5000",
+			DockerUser:        "private-user",
+			DockerPassword:    "private-secret",
+			DockerEmail:       "me@example.com",
+		}))
+	})
+
+	It("prefers a registry's credential helper", func() {
+		credentials, err := resolver.Resolve("123.dkr.ecr.us-east-1.amazonaws.com/repo:tag")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials.DockerUser).To(Equal("AWS"))
+		Expect(credentials.DockerPassword).To(Equal("ecr-secret"))
+		Expect(lookups).To(Equal([]string{"ecr-login 123.dkr.ecr.us-east-1.amazonaws.com"}))
+	})
+
+	Context("with a credential store", func() {
+		BeforeEach(func() {
+			config.CredsStore = "desktop"
+		})
+
+		It("uses it instead of the auths, with the docker hub login server", func() {
+			credentials, err := resolver.Resolve("ubuntu")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials).To(Equal(cc_messages.RegistryCredentials{DockerUser: "store-user", DockerPassword: "store-secret"}))
+			Expect(lookups).To(Equal([]string{"desktop " + cc_messages.DockerHubLoginServer}))
+		})
+	})
+
+	It("returns empty credentials for unknown registries", func() {
+		credentials, err := resolver.Resolve("unknown.example.com/repo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials.HasRegistryCredentials()).To(BeFalse())
+	})
+
+	It("rejects identity tokens", func() {
+		_, err := resolver.Resolve("token.example.com/repo")
+		Expect(err).To(Equal(dockerauth.ErrIdentityTokenUnsupported))
+
+		_, err = resolver.Resolve("token-helper.example.com/repo")
+		Expect(err).To(Equal(dockerauth.ErrIdentityTokenUnsupported))
+	})
+
+	It("reports broken auths and helpers", func() {
+		_, err := resolver.Resolve("bad.example.com/repo")
+		Expect(err).To(MatchError(ContainSubstring("invalid auth for bad.example.com")))
+
+		_, err = resolver.Resolve("broken.example.com/repo")
+		Expect(err).To(MatchError(ContainSubstring(`docker credential helper "broken" failed`)))
+	})
+
+	It("rejects malformed images", func() {
+		_, err := resolver.Resolve("user/Repo")
+		Expect(err).To(BeAssignableToTypeOf(cc_messages.InvalidDockerImageError{}))
+	})
+
+	Describe("filling messages", func() {
+		It("gives every message kind the same credentials", func() {
+			desireApp := &cc_messages.DesireAppRequestFromCC{DockerImageUrl: "registry.example.com:5000/team/repo"}
+			task := &cc_messages.TaskRequestFromCC{DockerPath: "registry.example.com:5000/team/repo"}
+			stagingData := &cc_messages.DockerStagingData{DockerImageUrl: "registry.example.com:5000/team/repo"}
+
+			Expect(resolver.FillDesireAppRequest(desireApp)).To(Succeed())
+			Expect(resolver.FillTaskRequest(task)).To(Succeed())
+			Expect(resolver.FillDockerStagingData(stagingData)).To(Succeed())
+
+			Expect(desireApp.DockerUser).To(Equal("private-user"))
+			Expect(task.RegistryCredentials).To(Equal(desireApp.RegistryCredentials))
+			Expect(stagingData.RegistryCredentials).To(Equal(desireApp.RegistryCredentials))
+		})
+
+		It("keeps credentials given in the message", func() {
+			desireApp := &cc_messages.DesireAppRequestFromCC{
+				DockerImageUrl:      "ubuntu",
+				RegistryCredentials: cc_messages.RegistryCredentials{DockerUser: "given-user", DockerPassword: "given-secret"},
+			}
+			Expect(resolver.FillDesireAppRequest(desireApp)).To(Succeed())
+			Expect(desireApp.DockerUser).To(Equal("given-user"))
+			Expect(desireApp.DockerPassword).To(Equal("given-secret"))
+		})
+
+		It("skips messages without an image", func() {
+			desireApp := &cc_messages.DesireAppRequestFromCC{DropletUri: "http://example.com/droplet"}
+			Expect(resolver.FillDesireAppRequest(desireApp)).To(Succeed())
+			Expect(desireApp.HasRegistryCredentials()).To(BeFalse())
+		})
+	})
+
+	Describe("LoadConfig", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "dockerauth")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("reads a config file", func() {
+			path := filepath.Join(dir, "config.json")
+			Expect(os.WriteFile(path, []byte(`{"auths":{"registry.example.com":{"username":"u","password":"p"}},"credsStore":"desktop","credHelpers":{"gcr.io":"gcloud"}}`), 0600)).To(Succeed())
+
+			config, err := dockerauth.LoadConfig(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).To(Equal(&dockerauth.Config{
+				Auths:       map[string]dockerauth.AuthConfig{"registry.example.com": {Username: "u", Password: "p"}},
+				CredsStore:  "desktop",
+				CredHelpers: map[string]string{"gcr.io": "gcloud"},
+			}))
+		})
+
+		It("treats a missing file as an empty config", func() {
+			config, err := dockerauth.LoadConfig(filepath.Join(dir, "missing.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).To(Equal(&dockerauth.Config{}))
+		})
+
+		It("rejects invalid files", func() {
+			path := filepath.Join(dir, "config.json")
+			Expect(os.WriteFile(path, []byte(`{`), 0600)).To(Succeed())
+
+			_, err := dockerauth.LoadConfig(path)
+			Expect(err).To(MatchError(ContainSubstring("invalid docker config")))
+		})
+
+		It("finds the config under DOCKER_CONFIG", func() {
+			previous, set := os.LookupEnv("DOCKER_CONFIG")
+			defer func() {
+				if set {
+					os.Setenv("DOCKER_CONFIG", previous)
+				} else {
+					os.Unsetenv("DOCKER_CONFIG")
+				}
+			}()
+
+			os.Setenv("DOCKER_CONFIG", dir)
+			path, err := dockerauth.DefaultConfigPath()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal(filepath.Join(dir, "config.json")))
+		})
+	})
+})
@@ -0,0 +1,339 @@
+package cc_messages
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// EnvironmentLayer says where an environment variable comes from. When the
+// same name is set in several layers the highest layer wins: system >
+// app > staging.
+type EnvironmentLayer int
+
+const (
+	// StagingEnvironmentLayer holds the environment the CC sends with a
+	// staging request.
+	StagingEnvironmentLayer EnvironmentLayer = iota
+
+	// AppEnvironmentLayer holds the environment the CC sends with a desired
+	// app or task.
+	AppEnvironmentLayer
+
+	// SystemEnvironmentLayer holds the variables the builders set
+	// themselves, such as PORT and CF_STACK.
+	SystemEnvironmentLayer
+)
+
+func (l EnvironmentLayer) String() string {
+	switch l {
+	case StagingEnvironmentLayer:
+		return "staging"
+	case AppEnvironmentLayer:
+		return "app"
+	case SystemEnvironmentLayer:
+		return "system"
+	default:
+		return fmt.Sprintf("EnvironmentLayer(%d)", int(l))
+	}
+}
+
+// ReservedEnvironmentVariablePrefixes and ReservedEnvironmentVariableNames
+// are reserved for the system layer when an Environment is strict.
+var (
+	ReservedEnvironmentVariablePrefixes = []string{"CF_", "VCAP_"}
+	ReservedEnvironmentVariableNames    = []string{"PORT"}
+)
+
+// CCProvidedEnvironmentVariables are the reserved names the CC itself sends,
+// so a strict Environment accepts them in every layer. The system layer
+// still wins when it sets them too.
+var CCProvidedEnvironmentVariables = []string{
+	// CF_STACK is sent in staging requests.
+	"CF_STACK",
+
+	// VCAP_APPLICATION and VCAP_SERVICES are sent in staging requests,
+	// desired apps and tasks.
+	"VCAP_APPLICATION",
+	"VCAP_SERVICES",
+
+	// VCAP_PLATFORM_OPTIONS is sent with the same messages when the CC is
+	// configured with a CredHub URL.
+	"VCAP_PLATFORM_OPTIONS",
+}
+
+func IsReservedEnvironmentVariable(name string) bool {
+	for _, reserved := range ReservedEnvironmentVariableNames {
+		if name == reserved {
+			return true
+		}
+	}
+	for _, prefix := range ReservedEnvironmentVariablePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCCProvidedEnvironmentVariable(name string) bool {
+	for _, provided := range CCProvidedEnvironmentVariables {
+		if name == provided {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvironmentLimits bound the environment handed to a container. A zero
+// limit is not enforced.
+type EnvironmentLimits struct {
+	MaxVariables int
+
+	// MaxVariableBytes bounds a single "NAME=value" entry.
+	MaxVariableBytes int
+
+	// MaxTotalBytes bounds the sum of every "NAME=value" entry.
+	MaxTotalBytes int
+}
+
+// DefaultEnvironmentLimits keep every entry under the 128KiB linux allows
+// for a single exec string and the whole environment well under ARG_MAX.
+var DefaultEnvironmentLimits = EnvironmentLimits{
+	MaxVariables:     1000,
+	MaxVariableBytes: 128*1024 - 1,
+	MaxTotalBytes:    1024 * 1024,
+}
+
+type DuplicateEnvironmentVariableError struct {
+	Name  string
+	Layer EnvironmentLayer
+}
+
+func (e DuplicateEnvironmentVariableError) Error() string {
+	return fmt.Sprintf("environment variable %q is set more than once in the %s environment", e.Name, e.Layer)
+}
+
+type ReservedEnvironmentVariableError struct {
+	Name  string
+	Layer EnvironmentLayer
+}
+
+func (e ReservedEnvironmentVariableError) Error() string {
+	return fmt.Sprintf("environment variable %q is reserved and cannot be set in the %s environment", e.Name, e.Layer)
+}
+
+type InvalidEnvironmentVariableError struct {
+	Name   string
+	Reason string
+}
+
+func (e InvalidEnvironmentVariableError) Error() string {
+	return fmt.Sprintf("invalid environment variable %q: %s", e.Name, e.Reason)
+}
+
+type EnvironmentLimitError struct {
+	Limit  string
+	Actual int
+	Max    int
+}
+
+func (e EnvironmentLimitError) Error() string {
+	return fmt.Sprintf("environment exceeds its %s limit: %d > %d", e.Limit, e.Actual, e.Max)
+}
+
+// EnvironmentOptions configure an Environment.
+type EnvironmentOptions struct {
+	Limits EnvironmentLimits
+
+	// Strict rejects a name set twice in the same layer and reserved names,
+	// other than CCProvidedEnvironmentVariables, outside the system layer.
+	// Otherwise the last value set in a layer wins, reserved names are
+	// accepted in every layer, and both are reported by Warnings.
+	Strict bool
+}
+
+// DefaultEnvironmentOptions are lenient, so that environments the CC
+// accepted are not rejected, and apply DefaultEnvironmentLimits.
+var DefaultEnvironmentOptions = EnvironmentOptions{
+	Limits: DefaultEnvironmentLimits,
+}
+
+type environmentEntry struct {
+	value string
+	layer EnvironmentLayer
+	order int
+}
+
+// Environment merges the layers of a container's environment. Variables
+// come out ordered by the layer that set them, lowest first, and then in
+// the order they were added, so the same messages always produce the same
+// environment.
+type Environment struct {
+	options  EnvironmentOptions
+	entries  map[string]environmentEntry
+	added    int
+	warnings []error
+}
+
+func NewEnvironment(options EnvironmentOptions) *Environment {
+	return &Environment{
+		options: options,
+		entries: make(map[string]environmentEntry),
+	}
+}
+
+// MergeEnvironment merges the staging, app and system environments with
+// DefaultEnvironmentOptions. Any of them may be nil.
+func MergeEnvironment(staging, app, system []*models.EnvironmentVariable) ([]*models.EnvironmentVariable, error) {
+	env := NewEnvironment(DefaultEnvironmentOptions)
+	for _, layer := range []struct {
+		layer     EnvironmentLayer
+		variables []*models.EnvironmentVariable
+	}{
+		{StagingEnvironmentLayer, staging},
+		{AppEnvironmentLayer, app},
+		{SystemEnvironmentLayer, system},
+	} {
+		err := env.Add(layer.layer, layer.variables...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return env.Variables(), nil
+}
+
+// Add sets variables in layer. Variables set in a lower layer than an
+// existing one are ignored. It fails, leaving the environment unchanged,
+// when a variable is invalid or a limit would be exceeded, and, when the
+// environment is strict, when a name is set twice in the layer or a lower
+// layer sets a reserved name.
+func (e *Environment) Add(layer EnvironmentLayer, variables ...*models.EnvironmentVariable) error {
+	entries := make(map[string]environmentEntry, len(e.entries)+len(variables))
+	for name, entry := range e.entries {
+		entries[name] = entry
+	}
+
+	var warnings []error
+	added := e.added
+	for _, variable := range variables {
+		if variable == nil {
+			continue
+		}
+
+		err := e.validateVariable(variable)
+		if err != nil {
+			return err
+		}
+
+		if layer != SystemEnvironmentLayer && IsReservedEnvironmentVariable(variable.Name) && !isCCProvidedEnvironmentVariable(variable.Name) {
+			err := ReservedEnvironmentVariableError{Name: variable.Name, Layer: layer}
+			if e.options.Strict {
+				return err
+			}
+			warnings = append(warnings, err)
+		}
+
+		existing, found := entries[variable.Name]
+		switch {
+		case found && existing.layer == layer:
+			err := DuplicateEnvironmentVariableError{Name: variable.Name, Layer: layer}
+			if e.options.Strict {
+				return err
+			}
+			warnings = append(warnings, err)
+		case found && existing.layer > layer:
+			continue
+		}
+
+		entries[variable.Name] = environmentEntry{value: variable.Value, layer: layer, order: added}
+		added++
+	}
+
+	err := e.checkLimits(entries)
+	if err != nil {
+		return err
+	}
+
+	e.entries = entries
+	e.added = added
+	e.warnings = append(e.warnings, warnings...)
+	return nil
+}
+
+// Warnings returns the duplicate and reserved names a lenient environment
+// accepted.
+func (e *Environment) Warnings() []error {
+	return e.warnings
+}
+
+// Lookup returns the value of name and the layer that set it.
+func (e *Environment) Lookup(name string) (string, EnvironmentLayer, bool) {
+	entry, found := e.entries[name]
+	return entry.value, entry.layer, found
+}
+
+func (e *Environment) Len() int {
+	return len(e.entries)
+}
+
+// Variables returns the merged environment, or nil when it is empty.
+func (e *Environment) Variables() []*models.EnvironmentVariable {
+	if len(e.entries) == 0 {
+		return nil
+	}
+
+	ordered := make([]*models.EnvironmentVariable, e.added)
+	for name, entry := range e.entries {
+		ordered[entry.order] = &models.EnvironmentVariable{Name: name, Value: entry.value}
+	}
+
+	variables := make([]*models.EnvironmentVariable, 0, len(e.entries))
+	for layer := StagingEnvironmentLayer; layer <= SystemEnvironmentLayer; layer++ {
+		for _, variable := range ordered {
+			if variable != nil && e.entries[variable.Name].layer == layer {
+				variables = append(variables, variable)
+			}
+		}
+	}
+	return variables
+}
+
+func (e *Environment) validateVariable(variable *models.EnvironmentVariable) error {
+	switch {
+	case variable.Name == "":
+		return InvalidEnvironmentVariableError{Name: variable.Name, Reason: "name cannot be empty"}
+	case strings.ContainsAny(variable.Name, "=\x00"):
+		return InvalidEnvironmentVariableError{Name: variable.Name, Reason: "name cannot contain '=' or NUL"}
+	case strings.ContainsRune(variable.Value, 0):
+		return InvalidEnvironmentVariableError{Name: variable.Name, Reason: "value cannot contain NUL"}
+	}
+
+	if max := e.options.Limits.MaxVariableBytes; max > 0 && entrySize(variable.Name, variable.Value) > max {
+		return EnvironmentLimitError{Limit: fmt.Sprintf("per variable size (%s)", variable.Name), Actual: entrySize(variable.Name, variable.Value), Max: max}
+	}
+	return nil
+}
+
+func (e *Environment) checkLimits(entries map[string]environmentEntry) error {
+	if max := e.options.Limits.MaxVariables; max > 0 && len(entries) > max {
+		return EnvironmentLimitError{Limit: "variable count", Actual: len(entries), Max: max}
+	}
+
+	if max := e.options.Limits.MaxTotalBytes; max > 0 {
+		total := 0
+		for name, entry := range entries {
+			total += entrySize(name, entry.value)
+		}
+		if total > max {
+			return EnvironmentLimitError{Limit: "total size", Actual: total, Max: max}
+		}
+	}
+	return nil
+}
+
+// entrySize is the length of the "NAME=value" string the variable becomes.
+func entrySize(name, value string) int {
+	return len(name) + 1 + len(value)
+}
@@ -0,0 +1,160 @@
+package cc_messages_test
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Environment", func() {
+	envVar := func(name, value string) *models.EnvironmentVariable {
+		return &models.EnvironmentVariable{Name: name, Value: value}
+	}
+
+	Describe("MergeEnvironment", func() {
+		It("lets system override app override staging, ordered by layer", func() {
+			env, err := cc_messages.MergeEnvironment(
+				[]*models.EnvironmentVariable{envVar("A", "staging"), envVar("B", "staging"), envVar("C", "staging")},
+				[]*models.EnvironmentVariable{envVar("D", "app"), envVar("B", "app")},
+				[]*models.EnvironmentVariable{envVar("PORT", "8080"), envVar("C", "system")},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(Equal([]*models.EnvironmentVariable{
+				envVar("A", "staging"),
+				envVar("D", "app"),
+				envVar("B", "app"),
+				envVar("PORT", "8080"),
+				envVar("C", "system"),
+			}))
+		})
+
+		It("returns nil for an empty environment", func() {
+			env, err := cc_messages.MergeEnvironment(nil, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(BeNil())
+		})
+
+		It("accepts the variables the CC provides in lower layers", func() {
+			env, err := cc_messages.MergeEnvironment(
+				nil,
+				[]*models.EnvironmentVariable{envVar("VCAP_APPLICATION", "{}"), envVar("CF_STACK", "cc-stack")},
+				[]*models.EnvironmentVariable{envVar("CF_STACK", "some-stack")},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(Equal([]*models.EnvironmentVariable{
+				envVar("VCAP_APPLICATION", "{}"),
+				envVar("CF_STACK", "some-stack"),
+			}))
+		})
+
+		It("accepts reserved names in lower layers, letting the system layer win", func() {
+			env, err := cc_messages.MergeEnvironment(
+				nil,
+				[]*models.EnvironmentVariable{envVar("PORT", "9090"), envVar("VCAP_APP_HOST", "0.0.0.0")},
+				[]*models.EnvironmentVariable{envVar("PORT", "8080")},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(Equal([]*models.EnvironmentVariable{
+				envVar("VCAP_APP_HOST", "0.0.0.0"),
+				envVar("PORT", "8080"),
+			}))
+		})
+
+		It("lets the last value win within a layer", func() {
+			env, err := cc_messages.MergeEnvironment([]*models.EnvironmentVariable{envVar("A", "1"), envVar("B", "2"), envVar("A", "3")}, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(Equal([]*models.EnvironmentVariable{envVar("B", "2"), envVar("A", "3")}))
+		})
+
+		DescribeTable("rejecting invalid variables",
+			func(variable *models.EnvironmentVariable) {
+				_, err := cc_messages.MergeEnvironment(nil, []*models.EnvironmentVariable{variable}, nil)
+				Expect(err).To(BeAssignableToTypeOf(cc_messages.InvalidEnvironmentVariableError{}))
+			},
+			Entry("an empty name", envVar("", "value")),
+			Entry("a name with '='", envVar("A=B", "value")),
+			Entry("a value with NUL", envVar("A", "a\x00b")),
+		)
+
+		It("enforces the default size limits", func() {
+			_, err := cc_messages.MergeEnvironment(nil, []*models.EnvironmentVariable{envVar("A", strings.Repeat("x", 128*1024))}, nil)
+			Expect(err).To(BeAssignableToTypeOf(cc_messages.EnvironmentLimitError{}))
+		})
+	})
+
+	Describe("strict environments", func() {
+		var env *cc_messages.Environment
+
+		BeforeEach(func() {
+			env = cc_messages.NewEnvironment(cc_messages.EnvironmentOptions{Strict: true})
+		})
+
+		DescribeTable("rejecting reserved names outside the system layer",
+			func(name string) {
+				err := env.Add(cc_messages.AppEnvironmentLayer, envVar(name, "value"))
+				Expect(err).To(Equal(cc_messages.ReservedEnvironmentVariableError{Name: name, Layer: cc_messages.AppEnvironmentLayer}))
+				Expect(env.Len()).To(Equal(0))
+			},
+			Entry("PORT", "PORT"),
+			Entry("a CF_ prefix", "CF_INSTANCE_IP"),
+			Entry("a VCAP_ prefix", "VCAP_APP_PORT"),
+		)
+
+		It("accepts the names the CC sends", func() {
+			for _, name := range cc_messages.CCProvidedEnvironmentVariables {
+				Expect(env.Add(cc_messages.StagingEnvironmentLayer, envVar(name, "value"))).To(Succeed())
+			}
+		})
+
+		It("rejects duplicates within a layer", func() {
+			err := env.Add(cc_messages.StagingEnvironmentLayer, envVar("A", "1"), envVar("A", "2"))
+			Expect(err).To(Equal(cc_messages.DuplicateEnvironmentVariableError{Name: "A", Layer: cc_messages.StagingEnvironmentLayer}))
+		})
+	})
+
+	It("reports what a lenient environment accepted", func() {
+		env := cc_messages.NewEnvironment(cc_messages.DefaultEnvironmentOptions)
+		Expect(env.Add(cc_messages.AppEnvironmentLayer, envVar("A", "1"), envVar("A", "2"), envVar("CF_FOO", "bar"))).To(Succeed())
+		Expect(env.Warnings()).To(Equal([]error{
+			cc_messages.DuplicateEnvironmentVariableError{Name: "A", Layer: cc_messages.AppEnvironmentLayer},
+			cc_messages.ReservedEnvironmentVariableError{Name: "CF_FOO", Layer: cc_messages.AppEnvironmentLayer},
+		}))
+	})
+
+	Describe("Add", func() {
+		var env *cc_messages.Environment
+
+		BeforeEach(func() {
+			env = cc_messages.NewEnvironment(cc_messages.EnvironmentOptions{
+				Limits: cc_messages.EnvironmentLimits{MaxVariables: 3, MaxTotalBytes: 20},
+			})
+			Expect(env.Add(cc_messages.AppEnvironmentLayer, envVar("A", "app"))).To(Succeed())
+		})
+
+		It("ignores variables a higher layer already set", func() {
+			Expect(env.Add(cc_messages.StagingEnvironmentLayer, envVar("A", "staging"))).To(Succeed())
+
+			value, layer, found := env.Lookup("A")
+			Expect(found).To(BeTrue())
+			Expect(value).To(Equal("app"))
+			Expect(layer).To(Equal(cc_messages.AppEnvironmentLayer))
+		})
+
+		It("leaves the environment unchanged when a limit is exceeded", func() {
+			err := env.Add(cc_messages.AppEnvironmentLayer, envVar("B", "1"), envVar("C", "2"), envVar("D", "3"))
+			Expect(err).To(Equal(cc_messages.EnvironmentLimitError{Limit: "variable count", Actual: 4, Max: 3}))
+
+			err = env.Add(cc_messages.SystemEnvironmentLayer, envVar("B", strings.Repeat("x", 20)))
+			Expect(err).To(Equal(cc_messages.EnvironmentLimitError{Limit: "total size", Actual: 27, Max: 20}))
+
+			Expect(env.Len()).To(Equal(1))
+			_, _, found := env.Lookup("B")
+			Expect(found).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,177 @@
+package cc_messages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+const (
+	FingerprintAlgorithm = "sha256"
+	FingerprintVersion   = "v3"
+
+	fingerprintPrefix = FingerprintAlgorithm + "-" + FingerprintVersion + ":"
+)
+
+// Fingerprint computes a deterministic ETag for the desired app, of the form
+// "sha256-v3:<hex digest>". It cannot fingerprint a sealed docker password;
+// use FingerprintWithKeyring for those.
+//
+// The digest is taken over a canonical JSON encoding of the request: object
+// keys are sorted, null and empty collections are dropped, the environment is
+// sorted by name with nil entries first, and the routes under every
+// routing_info key are sorted. The ETag itself is excluded. The docker
+// password is replaced by a hash of its plaintext, so that changing it
+// changes the fingerprint while sealing it, under any key and nonce, does
+// not. Any change to the canonical form must bump FingerprintVersion.
+func (d DesireAppRequestFromCC) Fingerprint() (string, error) {
+	return d.FingerprintWithKeyring(nil)
+}
+
+// FingerprintWithKeyring is Fingerprint, opening a sealed docker password
+// with keyring.
+func (d DesireAppRequestFromCC) FingerprintWithKeyring(keyring CredentialKeyring) (string, error) {
+	password, err := d.OpenDockerPassword(keyring)
+	if err != nil {
+		return "", err
+	}
+
+	d.ETag = ""
+	d.DockerPassword = ""
+	d.SealedDockerPassword = nil
+	if password != "" {
+		passwordSum := sha256.Sum256([]byte(password))
+		d.DockerPassword = FingerprintAlgorithm + ":" + hex.EncodeToString(passwordSum[:])
+	}
+
+	if len(d.Environment) > 0 {
+		env := append(d.Environment[:0:0], d.Environment...)
+		sort.SliceStable(env, func(i, j int) bool {
+			if env[i] == nil || env[j] == nil {
+				return env[i] == nil && env[j] != nil
+			}
+			if env[i].Name != env[j].Name {
+				return env[i].Name < env[j].Name
+			}
+			return env[i].Value < env[j].Value
+		})
+		d.Environment = env
+	}
+
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+
+	canonical, err := canonicalJSON(payload, func(path []string, value interface{}) interface{} {
+		if len(path) == 2 && path[0] == "routing_info" {
+			return sortedArray(value)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return fingerprintPrefix + hex.EncodeToString(sum[:]), nil
+}
+
+// CCDesiredAppFingerprint pairs the desired app's ProcessGuid with its
+// Fingerprint.
+func (d DesireAppRequestFromCC) CCDesiredAppFingerprint() (CCDesiredAppFingerprint, error) {
+	return d.CCDesiredAppFingerprintWithKeyring(nil)
+}
+
+func (d DesireAppRequestFromCC) CCDesiredAppFingerprintWithKeyring(keyring CredentialKeyring) (CCDesiredAppFingerprint, error) {
+	etag, err := d.FingerprintWithKeyring(keyring)
+	if err != nil {
+		return CCDesiredAppFingerprint{}, err
+	}
+
+	return CCDesiredAppFingerprint{ProcessGuid: d.ProcessGuid, ETag: etag}, nil
+}
+
+// canonicalJSON re-encodes payload with sorted keys and without null or
+// empty collections. rewrite is called bottom-up on every value with the
+// object keys leading to it.
+func canonicalJSON(payload []byte, rewrite func(path []string, value interface{}) interface{}) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(canonicalValue(nil, value, rewrite))
+}
+
+func canonicalValue(path []string, value interface{}, rewrite func([]string, interface{}) interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			child = canonicalValue(append(path[:len(path):len(path)], key), child, rewrite)
+			if isEmptyJSON(child) {
+				delete(v, key)
+			} else {
+				v[key] = child
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = canonicalValue(path, child, rewrite)
+		}
+	}
+
+	return rewrite(path, value)
+}
+
+func isEmptyJSON(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// sortedArray orders the elements of a JSON array by their canonical
+// encoding. Other values are returned unchanged.
+func sortedArray(value interface{}) interface{} {
+	array, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+
+	encoded := make([]string, len(array))
+	for i, element := range array {
+		elementJson, err := json.Marshal(element)
+		if err != nil {
+			return value
+		}
+		encoded[i] = string(elementJson)
+	}
+
+	sort.Sort(byEncoding{array: array, encoded: encoded})
+	return array
+}
+
+type byEncoding struct {
+	array   []interface{}
+	encoded []string
+}
+
+func (s byEncoding) Len() int           { return len(s.array) }
+func (s byEncoding) Less(i, j int) bool { return s.encoded[i] < s.encoded[j] }
+func (s byEncoding) Swap(i, j int) {
+	s.array[i], s.array[j] = s.array[j], s.array[i]
+	s.encoded[i], s.encoded[j] = s.encoded[j], s.encoded[i]
+}
@@ -0,0 +1,128 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fingerprint", func() {
+	var desiredApp cc_messages.DesireAppRequestFromCC
+
+	routeInfo := func(key, payload string) cc_messages.CCRouteInfo {
+		message := json.RawMessage(payload)
+		return cc_messages.CCRouteInfo{key: &message}
+	}
+
+	fingerprint := func(desiredApp cc_messages.DesireAppRequestFromCC) string {
+		etag, err := desiredApp.Fingerprint()
+		Expect(err).NotTo(HaveOccurred())
+		return etag
+	}
+
+	BeforeEach(func() {
+		desiredApp = cc_messages.DesireAppRequestFromCC{
+			ProcessGuid:  "process-guid",
+			DropletUri:   "http://droplet.uri",
+			Stack:        "some-stack",
+			StartCommand: "start",
+			MemoryMB:     128,
+			DiskMB:       512,
+			NumInstances: 2,
+			Environment: []*models.EnvironmentVariable{
+				{Name: "FOO", Value: "BAR"},
+				{Name: "BAZ", Value: "QUX"},
+			},
+			RoutingInfo: routeInfo(cc_messages.CC_HTTP_ROUTES, `[{"hostname":"a.example.com"},{"hostname":"b.example.com"}]`),
+			ETag:        "some-etag",
+		}
+	})
+
+	It("prefixes the digest with the algorithm and version", func() {
+		etag := fingerprint(desiredApp)
+		Expect(etag).To(HavePrefix("sha256-v3:"))
+		Expect(strings.TrimPrefix(etag, "sha256-v3:")).To(MatchRegexp("^[0-9a-f]{64}$"))
+	})
+
+	It("is stable across calls", func() {
+		Expect(fingerprint(desiredApp)).To(Equal(fingerprint(desiredApp)))
+	})
+
+	It("orders nil environment entries first instead of panicking", func() {
+		desiredApp.Environment = append(desiredApp.Environment, nil)
+		withNil := fingerprint(desiredApp)
+
+		desiredApp.Environment = append([]*models.EnvironmentVariable{nil}, desiredApp.Environment[:2]...)
+		Expect(fingerprint(desiredApp)).To(Equal(withNil))
+
+		var decoded cc_messages.DesireAppRequestFromCC
+		Expect(json.Unmarshal([]byte(`{"process_guid":"process-guid","environment":[null]}`), &decoded)).To(Succeed())
+		Expect(func() { fingerprint(decoded) }).NotTo(Panic())
+	})
+
+	It("ignores the etag", func() {
+		before := fingerprint(desiredApp)
+		desiredApp.ETag = "other-etag"
+		Expect(fingerprint(desiredApp)).To(Equal(before))
+	})
+
+	It("ignores the order of the environment", func() {
+		before := fingerprint(desiredApp)
+		desiredApp.Environment = []*models.EnvironmentVariable{
+			{Name: "BAZ", Value: "QUX"},
+			{Name: "FOO", Value: "BAR"},
+		}
+		Expect(fingerprint(desiredApp)).To(Equal(before))
+	})
+
+	It("does not reorder the caller's environment", func() {
+		fingerprint(desiredApp)
+		Expect(desiredApp.Environment[0].Name).To(Equal("FOO"))
+	})
+
+	It("ignores the order and formatting of routes", func() {
+		before := fingerprint(desiredApp)
+		desiredApp.RoutingInfo = routeInfo(cc_messages.CC_HTTP_ROUTES, `[ {"hostname": "b.example.com"}, {"hostname": "a.example.com"} ]`)
+		Expect(fingerprint(desiredApp)).To(Equal(before))
+	})
+
+	It("treats empty and missing collections the same", func() {
+		desiredApp.Environment = nil
+		before := fingerprint(desiredApp)
+		desiredApp.Environment = []*models.EnvironmentVariable{}
+		desiredApp.VolumeMounts = []*cc_messages.VolumeMount{}
+		Expect(fingerprint(desiredApp)).To(Equal(before))
+	})
+
+	It("changes when the desired state changes", func() {
+		before := fingerprint(desiredApp)
+
+		changed := desiredApp
+		changed.NumInstances = 3
+		Expect(fingerprint(changed)).NotTo(Equal(before))
+
+		changed = desiredApp
+		changed.Environment = []*models.EnvironmentVariable{{Name: "FOO", Value: "CHANGED"}, {Name: "BAZ", Value: "QUX"}}
+		Expect(fingerprint(changed)).NotTo(Equal(before))
+
+		changed = desiredApp
+		changed.RoutingInfo = routeInfo(cc_messages.CC_HTTP_ROUTES, `[{"hostname":"a.example.com"}]`)
+		Expect(fingerprint(changed)).NotTo(Equal(before))
+	})
+
+	Describe("CCDesiredAppFingerprint", func() {
+		It("pairs the process guid with the fingerprint", func() {
+			appFingerprint, err := desiredApp.CCDesiredAppFingerprint()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(appFingerprint).To(Equal(cc_messages.CCDesiredAppFingerprint{
+				ProcessGuid: "process-guid",
+				ETag:        fingerprint(desiredApp),
+			}))
+		})
+	})
+})
@@ -0,0 +1,328 @@
+package cc_messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	BUILDPACK_LIFECYCLE = "buildpack"
+	DOCKER_LIFECYCLE    = "docker"
+	CNB_LIFECYCLE       = "cnb"
+)
+
+var (
+	ErrLifecycleNameEmpty         = errors.New("Invalid lifecycle: empty lifecycle name")
+	ErrLifecycleStagingDataEmpty  = errors.New("Invalid lifecycle: missing staging data type")
+	ErrLifecycleNameMismatch      = errors.New("Invalid lifecycle: staging data type belongs to another lifecycle")
+	ErrLifecycleAlreadyRegistered = errors.New("Invalid lifecycle: already registered")
+
+	ErrLifecycleStagingResultEmpty = errors.New("Invalid lifecycle: no staging result type registered")
+	ErrStagingResultMissing        = errors.New("Invalid staging response: missing result")
+)
+
+// Lifecycle describes how the messages of a single lifecycle (e.g.
+// "buildpack") are decoded and validated.
+type Lifecycle struct {
+	Name string
+
+	// StagingData is a zero value of the type StagingRequestFromCC.LifecycleData
+	// decodes into. Its LifecycleName must return Name.
+	StagingData LifecycleData
+
+	// StagingResult, if set, is a zero value of the type
+	// StagingResponseForCC.Result decodes into.
+	StagingResult interface{}
+
+	// ValidateStagingData, if set, validates decoded staging data. Paths are
+	// relative to the lifecycle data.
+	ValidateStagingData func(LifecycleData) ValidationError
+
+	// ValidateTaskRequest, if set, validates the lifecycle specific fields
+	// of a TaskRequestFromCC. Lifecycles without it cannot run tasks.
+	ValidateTaskRequest func(TaskRequestFromCC) ValidationError
+}
+
+type LifecycleRegistry struct {
+	lock       sync.RWMutex
+	lifecycles map[string]Lifecycle
+}
+
+func NewLifecycleRegistry() *LifecycleRegistry {
+	return &LifecycleRegistry{
+		lifecycles: map[string]Lifecycle{},
+	}
+}
+
+func (r *LifecycleRegistry) Register(lifecycle Lifecycle) error {
+	if lifecycle.Name == "" {
+		return ErrLifecycleNameEmpty
+	}
+
+	if lifecycle.StagingData == nil {
+		return ErrLifecycleStagingDataEmpty
+	}
+
+	if lifecycle.StagingData.LifecycleName() != lifecycle.Name {
+		return ErrLifecycleNameMismatch
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, found := r.lifecycles[lifecycle.Name]; found {
+		return ErrLifecycleAlreadyRegistered
+	}
+
+	r.lifecycles[lifecycle.Name] = lifecycle
+	return nil
+}
+
+func (r *LifecycleRegistry) Lookup(name string) (Lifecycle, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	lifecycle, found := r.lifecycles[name]
+	return lifecycle, found
+}
+
+// Names returns the registered lifecycle names in sorted order.
+func (r *LifecycleRegistry) Names() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	names := make([]string, 0, len(r.lifecycles))
+	for name := range r.lifecycles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *LifecycleRegistry) namesList() string {
+	names := r.Names()
+	for i := range names {
+		names[i] = fmt.Sprintf("%q", names[i])
+	}
+	return strings.Join(names, ", ")
+}
+
+// DefaultLifecycleRegistry holds the buildpack, docker and cnb lifecycles and is
+// used when decoding and validating messages.
+var DefaultLifecycleRegistry = NewLifecycleRegistry()
+
+func RegisterLifecycle(lifecycle Lifecycle) error {
+	return DefaultLifecycleRegistry.Register(lifecycle)
+}
+
+func init() {
+	mustRegisterLifecycle(Lifecycle{
+		Name:                BUILDPACK_LIFECYCLE,
+		StagingData:         BuildpackStagingData{},
+		StagingResult:       BuildpackStagingResult{},
+		ValidateStagingData: validateBuildpackStagingData,
+		ValidateTaskRequest: validateDropletTaskRequest(BUILDPACK_LIFECYCLE),
+	})
+
+	mustRegisterLifecycle(Lifecycle{
+		Name:                DOCKER_LIFECYCLE,
+		StagingData:         DockerStagingData{},
+		StagingResult:       DockerStagingResult{},
+		ValidateStagingData: validateDockerStagingData,
+		ValidateTaskRequest: validateDockerTaskRequest,
+	})
+
+	mustRegisterLifecycle(Lifecycle{
+		Name:                CNB_LIFECYCLE,
+		StagingData:         CNBStagingData{},
+		StagingResult:       CNBStagingResult{},
+		ValidateStagingData: validateCNBStagingData,
+		ValidateTaskRequest: validateDropletTaskRequest(CNB_LIFECYCLE),
+	})
+}
+
+func mustRegisterLifecycle(lifecycle Lifecycle) {
+	err := RegisterLifecycle(lifecycle)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// MalformedStagingResultError is returned when a staging response's result
+// does not decode into its lifecycle's StagingResult type.
+type MalformedStagingResultError struct {
+	Lifecycle string
+	Err       error
+}
+
+func (e MalformedStagingResultError) Error() string {
+	return fmt.Sprintf("Invalid staging response: malformed %s result: %s", e.Lifecycle, e.Err)
+}
+
+func (r *LifecycleRegistry) DecodeStagingResult(name string, response StagingResponseForCC) (interface{}, error) {
+	lifecycle, found := r.Lookup(name)
+	if !found {
+		return nil, UnknownLifecycleError{Lifecycle: name}
+	}
+
+	if lifecycle.StagingResult == nil {
+		return nil, ErrLifecycleStagingResultEmpty
+	}
+
+	if response.Result == nil {
+		return nil, ErrStagingResultMissing
+	}
+
+	result := newZeroValue(lifecycle.StagingResult)
+	err := json.Unmarshal(*response.Result, result)
+	if err != nil {
+		return nil, MalformedStagingResultError{Lifecycle: name, Err: err}
+	}
+
+	return dereference(result), nil
+}
+
+func (r *LifecycleRegistry) ValidateTaskRequest(t TaskRequestFromCC) error {
+	var validationError ValidationError
+
+	lifecycle, found := r.Lookup(t.Lifecycle)
+	if !found {
+		validationError = validationError.Append("lifecycle", "must be one of "+r.namesList())
+	} else if lifecycle.ValidateTaskRequest == nil {
+		validationError = validationError.Append("lifecycle", "does not support tasks")
+	} else {
+		validationError = append(validationError, lifecycle.ValidateTaskRequest(t)...)
+	}
+
+	if t.CompletionCallbackUrl != "" {
+		callbackUrl, err := url.Parse(t.CompletionCallbackUrl)
+		if err != nil || !callbackUrl.IsAbs() || callbackUrl.Host == "" {
+			validationError = validationError.Append("completion_callback", "must be an absolute URL")
+		}
+	}
+
+	return validationError.ToError()
+}
+
+func newZeroValue(prototype interface{}) interface{} {
+	return reflect.New(reflect.TypeOf(prototype)).Interface()
+}
+
+func dereference(pointer interface{}) interface{} {
+	return reflect.ValueOf(pointer).Elem().Interface()
+}
+
+func validateBuildpackStagingData(data LifecycleData) ValidationError {
+	var validationError ValidationError
+
+	buildpackData := data.(BuildpackStagingData)
+	if buildpackData.AppBitsDownloadUri == "" {
+		validationError = validationError.Append("app_bits_download_uri", "cannot be empty")
+	}
+	if buildpackData.DropletUploadUri == "" {
+		validationError = validationError.Append("droplet_upload_uri", "cannot be empty")
+	}
+
+	return validationError
+}
+
+func validateDockerStagingData(data LifecycleData) ValidationError {
+	var validationError ValidationError
+
+	dockerData := data.(DockerStagingData)
+	if dockerData.DockerImageUrl == "" {
+		validationError = validationError.Append("docker_image", "cannot be empty")
+	}
+	validationError = validateDockerImage(validationError, "docker_image", dockerData.DockerImageUrl)
+	validationError = append(validationError, dockerData.RegistryCredentials.validate()...)
+
+	return validationError
+}
+
+func validateCNBStagingData(data LifecycleData) ValidationError {
+	var validationError ValidationError
+
+	cnbData := data.(CNBStagingData)
+	if cnbData.AppBitsDownloadUri == "" {
+		validationError = validationError.Append("app_bits_download_uri", "cannot be empty")
+	}
+	if cnbData.DropletUploadUri == "" {
+		validationError = validationError.Append("droplet_upload_uri", "cannot be empty")
+	}
+	for i, buildpack := range cnbData.Buildpacks {
+		if buildpack.Url == "" {
+			validationError = validationError.Append(fmt.Sprintf("buildpacks[%d].url", i), "cannot be empty")
+		}
+	}
+	registries := make([]string, 0, len(cnbData.Credentials))
+	for registry := range cnbData.Credentials {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+	for _, registry := range registries {
+		if cnbData.Credentials[registry].Username == "" {
+			validationError = validationError.Append(fmt.Sprintf("credentials[%q].username", registry), "cannot be empty")
+		}
+	}
+
+	return validationError
+}
+
+// validateDropletTaskRequest validates tasks for lifecycles that run a
+// droplet downloaded from droplet_uri.
+func validateDropletTaskRequest(lifecycleName string) func(TaskRequestFromCC) ValidationError {
+	return func(t TaskRequestFromCC) ValidationError {
+		var validationError ValidationError
+
+		if t.DropletUri == "" {
+			validationError = validationError.Append("droplet_uri", "cannot be empty for the "+lifecycleName+" lifecycle")
+		}
+		if t.DropletHash == "" {
+			validationError = validationError.Append("droplet_hash", "cannot be empty for the "+lifecycleName+" lifecycle")
+		}
+		if t.DockerPath != "" {
+			validationError = validationError.Append("docker_path", "cannot be set for the "+lifecycleName+" lifecycle")
+		}
+		if t.DockerLoginServer != "" {
+			validationError = validationError.Append("docker_login_server", "cannot be set for the "+lifecycleName+" lifecycle")
+		}
+		if t.DockerUser != "" {
+			validationError = validationError.Append("docker_user", "cannot be set for the "+lifecycleName+" lifecycle")
+		}
+		if t.DockerPassword != "" {
+			validationError = validationError.Append("docker_password", "cannot be set for the "+lifecycleName+" lifecycle")
+		}
+		if t.SealedDockerPassword != nil {
+			validationError = validationError.Append("sealed_docker_password", "cannot be set for the "+lifecycleName+" lifecycle")
+		}
+		if t.DockerEmail != "" {
+			validationError = validationError.Append("docker_email", "cannot be set for the "+lifecycleName+" lifecycle")
+		}
+
+		return validationError
+	}
+}
+
+func validateDockerTaskRequest(t TaskRequestFromCC) ValidationError {
+	var validationError ValidationError
+
+	if t.DockerPath == "" {
+		validationError = validationError.Append("docker_path", "cannot be empty for the docker lifecycle")
+	}
+	validationError = validateDockerImage(validationError, "docker_path", t.DockerPath)
+	validationError = append(validationError, t.RegistryCredentials.validate()...)
+	if t.DropletUri != "" {
+		validationError = validationError.Append("droplet_uri", "cannot be set for the docker lifecycle")
+	}
+	if t.DropletHash != "" {
+		validationError = validationError.Append("droplet_hash", "cannot be set for the docker lifecycle")
+	}
+
+	return validationError
+}
@@ -0,0 +1,206 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeStagingData struct {
+	Image string `json:"image"`
+}
+
+func (fakeStagingData) LifecycleName() string { return "fake" }
+
+type fakeStagingResult struct {
+	ProcessTypes map[string]string `json:"process_types"`
+}
+
+var _ = Describe("Lifecycles", func() {
+	var (
+		registry      *cc_messages.LifecycleRegistry
+		fakeLifecycle cc_messages.Lifecycle
+	)
+
+	rawMessage := func(payload string) *json.RawMessage {
+		message := json.RawMessage(payload)
+		return &message
+	}
+
+	BeforeEach(func() {
+		registry = cc_messages.NewLifecycleRegistry()
+		fakeLifecycle = cc_messages.Lifecycle{
+			Name:          "fake",
+			StagingData:   fakeStagingData{},
+			StagingResult: fakeStagingResult{},
+			ValidateStagingData: func(data cc_messages.LifecycleData) cc_messages.ValidationError {
+				var validationError cc_messages.ValidationError
+				if data.(fakeStagingData).Image == "" {
+					validationError = validationError.Append("image", "cannot be empty")
+				}
+				return validationError
+			},
+		}
+	})
+
+	Describe("DefaultLifecycleRegistry", func() {
+		It("contains the buildpack, cnb and docker lifecycles", func() {
+			Expect(cc_messages.DefaultLifecycleRegistry.Names()).To(Equal([]string{"buildpack", "cnb", "docker"}))
+		})
+	})
+
+	Describe("Register", func() {
+		It("registers the lifecycle", func() {
+			Expect(registry.Register(fakeLifecycle)).To(Succeed())
+
+			lifecycle, found := registry.Lookup("fake")
+			Expect(found).To(BeTrue())
+			Expect(lifecycle.Name).To(Equal("fake"))
+			Expect(registry.Names()).To(Equal([]string{"fake"}))
+		})
+
+		It("errors when the name is empty", func() {
+			fakeLifecycle.Name = ""
+			Expect(registry.Register(fakeLifecycle)).To(Equal(cc_messages.ErrLifecycleNameEmpty))
+		})
+
+		It("errors when the staging data type is missing", func() {
+			fakeLifecycle.StagingData = nil
+			Expect(registry.Register(fakeLifecycle)).To(Equal(cc_messages.ErrLifecycleStagingDataEmpty))
+		})
+
+		It("errors when the staging data type belongs to another lifecycle", func() {
+			fakeLifecycle.StagingData = cc_messages.DockerStagingData{}
+			Expect(registry.Register(fakeLifecycle)).To(Equal(cc_messages.ErrLifecycleNameMismatch))
+		})
+
+		It("errors when the lifecycle is already registered", func() {
+			Expect(registry.Register(fakeLifecycle)).To(Succeed())
+			Expect(registry.Register(fakeLifecycle)).To(Equal(cc_messages.ErrLifecycleAlreadyRegistered))
+		})
+	})
+
+	Context("with a registered lifecycle", func() {
+		var stagingRequest cc_messages.StagingRequestFromCC
+
+		BeforeEach(func() {
+			Expect(registry.Register(fakeLifecycle)).To(Succeed())
+			stagingRequest = cc_messages.StagingRequestFromCC{
+				AppId:         "app-id",
+				Lifecycle:     "fake",
+				LifecycleData: rawMessage(`{"image": "some-image"}`),
+			}
+		})
+
+		Describe("DecodeStagingData", func() {
+			It("decodes into the registered staging data type", func() {
+				data, err := registry.DecodeStagingData(stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(data).To(Equal(fakeStagingData{Image: "some-image"}))
+			})
+		})
+
+		Describe("ValidateStagingRequest", func() {
+			It("accepts valid lifecycle data", func() {
+				Expect(registry.ValidateStagingRequest(stagingRequest)).To(Succeed())
+			})
+
+			It("reports lifecycle data errors under lifecycle_data", func() {
+				stagingRequest.LifecycleData = rawMessage(`{}`)
+
+				err := registry.ValidateStagingRequest(stagingRequest)
+				Expect(err).To(MatchError("Invalid fields: lifecycle_data.image: cannot be empty"))
+			})
+
+			It("reports malformed lifecycle data", func() {
+				stagingRequest.LifecycleData = rawMessage(`{"image": 1}`)
+
+				err := registry.ValidateStagingRequest(stagingRequest)
+				Expect(err).To(MatchError("Invalid fields: lifecycle_data: is malformed"))
+			})
+
+			It("reports unregistered lifecycles", func() {
+				stagingRequest.Lifecycle = "buildpack"
+
+				err := registry.ValidateStagingRequest(stagingRequest)
+				Expect(err).To(MatchError(`Invalid fields: lifecycle: must be one of "fake"`))
+			})
+		})
+
+		Describe("DecodeStagingResult", func() {
+			It("decodes into the registered staging result type", func() {
+				response := cc_messages.StagingResponseForCC{
+					Result: rawMessage(`{"process_types": {"web": "./start"}}`),
+				}
+
+				result, err := registry.DecodeStagingResult("fake", response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(fakeStagingResult{
+					ProcessTypes: map[string]string{"web": "./start"},
+				}))
+			})
+
+			It("returns a MalformedStagingResultError for malformed results", func() {
+				response := cc_messages.StagingResponseForCC{
+					Result: rawMessage(`{"process_types": "not-a-map"}`),
+				}
+
+				_, err := registry.DecodeStagingResult("fake", response)
+				Expect(err).To(BeAssignableToTypeOf(cc_messages.MalformedStagingResultError{}))
+				Expect(err.(cc_messages.MalformedStagingResultError).Lifecycle).To(Equal("fake"))
+				Expect(err).To(MatchError(HavePrefix("Invalid staging response: malformed fake result: ")))
+			})
+
+			It("errors when the response has no result", func() {
+				_, err := registry.DecodeStagingResult("fake", cc_messages.StagingResponseForCC{})
+				Expect(err).To(Equal(cc_messages.ErrStagingResultMissing))
+			})
+
+			It("errors when the lifecycle has no result type", func() {
+				withoutResult := cc_messages.NewLifecycleRegistry()
+				fakeLifecycle.StagingResult = nil
+				Expect(withoutResult.Register(fakeLifecycle)).To(Succeed())
+
+				_, err := withoutResult.DecodeStagingResult("fake", cc_messages.StagingResponseForCC{})
+				Expect(err).To(Equal(cc_messages.ErrLifecycleStagingResultEmpty))
+			})
+
+			It("has a result type for every default lifecycle", func() {
+				for _, name := range cc_messages.DefaultLifecycleRegistry.Names() {
+					lifecycle, _ := cc_messages.DefaultLifecycleRegistry.Lookup(name)
+					Expect(lifecycle.StagingResult).NotTo(BeNil(), name)
+				}
+			})
+		})
+
+		Describe("ValidateTaskRequest", func() {
+			It("rejects lifecycles that cannot run tasks", func() {
+				err := registry.ValidateTaskRequest(cc_messages.TaskRequestFromCC{Lifecycle: "fake"})
+				Expect(err).To(MatchError("Invalid fields: lifecycle: does not support tasks"))
+			})
+		})
+	})
+
+	Describe("StagingRequestFromCC.Validate", func() {
+		It("validates buildpack lifecycle data", func() {
+			stagingRequest := cc_messages.StagingRequestFromCC{
+				AppId:         "app-id",
+				Lifecycle:     "buildpack",
+				LifecycleData: rawMessage(`{"app_bits_download_uri": "http://app-bits"}`),
+			}
+
+			err := stagingRequest.Validate()
+			Expect(err).To(MatchError("Invalid fields: lifecycle_data.droplet_upload_uri: cannot be empty"))
+		})
+
+		It("requires lifecycle data", func() {
+			stagingRequest := cc_messages.StagingRequestFromCC{Lifecycle: "docker"}
+
+			err := stagingRequest.Validate()
+			Expect(err).To(MatchError("Invalid fields: app_id: cannot be empty, lifecycle_data: cannot be empty"))
+		})
+	})
+})
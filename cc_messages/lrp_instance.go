@@ -13,12 +13,20 @@ const (
 	LRPInstanceStateUnknown  LRPInstanceState = "UNKNOWN"
 )
 
+// LRPInstance is an app instance as reported to the CC.
+//
+// Details is the free text the CC has always displayed for an instance and
+// is kept for compatibility; Reason is the typed code to group by. Details
+// only elaborates on Reason: it is empty whenever Reason is, and otherwise
+// holds the placement error, the crash reason, or a fixed description of
+// the reason.
 type LRPInstance struct {
 	ProcessGuid  string                  `json:"process_guid"`
 	InstanceGuid string                  `json:"instance_guid"`
 	Index        uint                    `json:"index"`
 	State        LRPInstanceState        `json:"state"`
 	Details      string                  `json:"details,omitempty"`
+	Reason       LRPInstanceReason       `json:"reason,omitempty"`
 	Host         string                  `json:"host,omitempty"`
 	Port         uint16                  `json:"port,omitempty"`
 	NetInfo      models.ActualLRPNetInfo `json:"net_info"`
@@ -0,0 +1,104 @@
+package cc_messages
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// LRPInstanceReason is a stable code for why an instance is in its state,
+// meant for grouping. LRPInstance.Details carries the human readable text
+// for it; see lrpInstanceDetails.
+type LRPInstanceReason string
+
+const (
+	LRPInstanceReasonPlacementFailed   LRPInstanceReason = "PLACEMENT_FAILED"
+	LRPInstanceReasonCrashed           LRPInstanceReason = "CRASHED"
+	LRPInstanceReasonCrashLimitReached LRPInstanceReason = "CRASH_LIMIT_REACHED"
+	LRPInstanceReasonEvacuating        LRPInstanceReason = "EVACUATING"
+	LRPInstanceReasonCellUnresponsive  LRPInstanceReason = "CELL_UNRESPONSIVE"
+	LRPInstanceReasonMissing           LRPInstanceReason = "MISSING"
+	LRPInstanceReasonUnrecognizedState LRPInstanceReason = "UNRECOGNIZED_STATE"
+)
+
+type InvalidLRPInstanceStateTransitionError struct {
+	From LRPInstanceState
+	To   LRPInstanceState
+}
+
+func (e InvalidLRPInstanceStateTransitionError) Error() string {
+	return fmt.Sprintf("invalid lrp instance state transition from %q to %q", e.From, e.To)
+}
+
+// lrpInstanceStateTransitions lists the states each state may move to.
+//
+// A starting instance runs, crashes, or is stopped. A running instance
+// crashes, is stopped, or starts over when it is rescheduled. A crashed
+// instance starts again until it reaches the restart limit, at which point
+// it is DOWN for good. UNKNOWN covers the loss of contact with an
+// instance's cell: the instance comes back as whatever the cell reports or
+// is replaced once the cell is declared lost. A DOWN instance only starts
+// again when it is desired again.
+var lrpInstanceStateTransitions = map[LRPInstanceState][]LRPInstanceState{
+	LRPInstanceStateStarting: {LRPInstanceStateRunning, LRPInstanceStateCrashed, LRPInstanceStateDown, LRPInstanceStateUnknown},
+	LRPInstanceStateRunning:  {LRPInstanceStateStarting, LRPInstanceStateCrashed, LRPInstanceStateDown, LRPInstanceStateUnknown},
+	LRPInstanceStateCrashed:  {LRPInstanceStateStarting, LRPInstanceStateDown},
+	LRPInstanceStateDown:     {LRPInstanceStateStarting},
+	LRPInstanceStateUnknown:  {LRPInstanceStateStarting, LRPInstanceStateRunning, LRPInstanceStateCrashed, LRPInstanceStateDown},
+}
+
+func (s LRPInstanceState) Valid() bool {
+	_, ok := lrpInstanceStateTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether an instance may move from s to next.
+// Staying in the same state is always allowed.
+func (s LRPInstanceState) CanTransitionTo(next LRPInstanceState) bool {
+	if s == next {
+		return s.Valid()
+	}
+
+	for _, state := range lrpInstanceStateTransitions[s] {
+		if state == next {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateLRPInstanceStateTransition(from, to LRPInstanceState) error {
+	if !from.CanTransitionTo(to) {
+		return InvalidLRPInstanceStateTransitionError{From: from, To: to}
+	}
+	return nil
+}
+
+// DeriveLRPInstanceState derives an instance's state and reason from its
+// ActualLRP. An instance on a suspect cell is UNKNOWN. A crashed instance is
+// DOWN once its CrashCount reaches maxRestarts, as bbs no longer restarts
+// it, and CRASHED before that.
+func DeriveLRPInstanceState(actual *models.ActualLRP, maxRestarts int32) (LRPInstanceState, LRPInstanceReason) {
+	if actual.Presence == models.ActualLRP_Suspect {
+		return LRPInstanceStateUnknown, LRPInstanceReasonCellUnresponsive
+	}
+
+	switch actual.State {
+	case models.ActualLRPStateUnclaimed:
+		if actual.PlacementError != "" {
+			return LRPInstanceStateStarting, LRPInstanceReasonPlacementFailed
+		}
+		return LRPInstanceStateStarting, ""
+	case models.ActualLRPStateClaimed:
+		return LRPInstanceStateStarting, ""
+	case models.ActualLRPStateRunning:
+		return LRPInstanceStateRunning, ""
+	case models.ActualLRPStateCrashed:
+		if actual.CrashCount >= maxRestarts {
+			return LRPInstanceStateDown, LRPInstanceReasonCrashLimitReached
+		}
+		return LRPInstanceStateCrashed, LRPInstanceReasonCrashed
+	default:
+		return LRPInstanceStateUnknown, LRPInstanceReasonUnrecognizedState
+	}
+}
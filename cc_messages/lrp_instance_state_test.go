@@ -0,0 +1,98 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LRPInstanceState", func() {
+	It("knows every state", func() {
+		for _, state := range []cc_messages.LRPInstanceState{
+			cc_messages.LRPInstanceStateStarting,
+			cc_messages.LRPInstanceStateRunning,
+			cc_messages.LRPInstanceStateCrashed,
+			cc_messages.LRPInstanceStateDown,
+			cc_messages.LRPInstanceStateUnknown,
+		} {
+			Expect(state.Valid()).To(BeTrue(), string(state))
+		}
+		Expect(cc_messages.LRPInstanceState("BOGUS").Valid()).To(BeFalse())
+	})
+
+	It("encodes the reason next to the details", func() {
+		payload, err := json.Marshal(cc_messages.LRPInstance{
+			ProcessGuid: "process-guid",
+			State:       cc_messages.LRPInstanceStateDown,
+			Details:     "exit status 1",
+			Reason:      cc_messages.LRPInstanceReasonCrashLimitReached,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		var decoded map[string]interface{}
+		Expect(json.Unmarshal(payload, &decoded)).To(Succeed())
+		Expect(decoded).To(HaveKeyWithValue("details", "exit status 1"))
+		Expect(decoded).To(HaveKeyWithValue("reason", "CRASH_LIMIT_REACHED"))
+	})
+
+	It("omits an empty reason", func() {
+		payload, err := json.Marshal(cc_messages.LRPInstance{State: cc_messages.LRPInstanceStateRunning})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(payload)).NotTo(ContainSubstring(`"reason"`))
+	})
+
+	DescribeTable("ValidateLRPInstanceStateTransition",
+		func(from, to cc_messages.LRPInstanceState, valid bool) {
+			err := cc_messages.ValidateLRPInstanceStateTransition(from, to)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(Equal(cc_messages.InvalidLRPInstanceStateTransitionError{From: from, To: to}))
+			}
+		},
+		Entry("starting to running", cc_messages.LRPInstanceStateStarting, cc_messages.LRPInstanceStateRunning, true),
+		Entry("running to crashed", cc_messages.LRPInstanceStateRunning, cc_messages.LRPInstanceStateCrashed, true),
+		Entry("crashed to starting", cc_messages.LRPInstanceStateCrashed, cc_messages.LRPInstanceStateStarting, true),
+		Entry("crashed to down", cc_messages.LRPInstanceStateCrashed, cc_messages.LRPInstanceStateDown, true),
+		Entry("running to unknown", cc_messages.LRPInstanceStateRunning, cc_messages.LRPInstanceStateUnknown, true),
+		Entry("unknown to running", cc_messages.LRPInstanceStateUnknown, cc_messages.LRPInstanceStateRunning, true),
+		Entry("down to starting", cc_messages.LRPInstanceStateDown, cc_messages.LRPInstanceStateStarting, true),
+		Entry("running to running", cc_messages.LRPInstanceStateRunning, cc_messages.LRPInstanceStateRunning, true),
+		Entry("crashed to running", cc_messages.LRPInstanceStateCrashed, cc_messages.LRPInstanceStateRunning, false),
+		Entry("crashed to unknown", cc_messages.LRPInstanceStateCrashed, cc_messages.LRPInstanceStateUnknown, false),
+		Entry("down to running", cc_messages.LRPInstanceStateDown, cc_messages.LRPInstanceStateRunning, false),
+		Entry("down to crashed", cc_messages.LRPInstanceStateDown, cc_messages.LRPInstanceStateCrashed, false),
+		Entry("an unknown state", cc_messages.LRPInstanceState("BOGUS"), cc_messages.LRPInstanceState("BOGUS"), false),
+	)
+
+	DescribeTable("DeriveLRPInstanceState",
+		func(state string, presence models.ActualLRP_Presence, placementError string, crashCount int32, expectedState cc_messages.LRPInstanceState, expectedReason cc_messages.LRPInstanceReason) {
+			actual := &models.ActualLRP{
+				ActualLRPKey:   models.NewActualLRPKey("process-guid", 0, "domain"),
+				State:          state,
+				Presence:       presence,
+				PlacementError: placementError,
+				CrashCount:     crashCount,
+			}
+
+			derivedState, reason := cc_messages.DeriveLRPInstanceState(actual, 3)
+			Expect(derivedState).To(Equal(expectedState))
+			Expect(reason).To(Equal(expectedReason))
+		},
+		Entry("unclaimed", models.ActualLRPStateUnclaimed, models.ActualLRP_Ordinary, "", int32(0), cc_messages.LRPInstanceStateStarting, cc_messages.LRPInstanceReason("")),
+		Entry("unclaimed with a placement error", models.ActualLRPStateUnclaimed, models.ActualLRP_Ordinary, "insufficient resources", int32(0), cc_messages.LRPInstanceStateStarting, cc_messages.LRPInstanceReasonPlacementFailed),
+		Entry("claimed", models.ActualLRPStateClaimed, models.ActualLRP_Ordinary, "", int32(0), cc_messages.LRPInstanceStateStarting, cc_messages.LRPInstanceReason("")),
+		Entry("running", models.ActualLRPStateRunning, models.ActualLRP_Ordinary, "", int32(0), cc_messages.LRPInstanceStateRunning, cc_messages.LRPInstanceReason("")),
+		Entry("running after crashing", models.ActualLRPStateRunning, models.ActualLRP_Ordinary, "", int32(2), cc_messages.LRPInstanceStateRunning, cc_messages.LRPInstanceReason("")),
+		Entry("crashed below the restart limit", models.ActualLRPStateCrashed, models.ActualLRP_Ordinary, "", int32(2), cc_messages.LRPInstanceStateCrashed, cc_messages.LRPInstanceReasonCrashed),
+		Entry("crashed at the restart limit", models.ActualLRPStateCrashed, models.ActualLRP_Ordinary, "", int32(3), cc_messages.LRPInstanceStateDown, cc_messages.LRPInstanceReasonCrashLimitReached),
+		Entry("evacuating", models.ActualLRPStateRunning, models.ActualLRP_Evacuating, "", int32(0), cc_messages.LRPInstanceStateRunning, cc_messages.LRPInstanceReason("")),
+		Entry("suspect", models.ActualLRPStateRunning, models.ActualLRP_Suspect, "", int32(0), cc_messages.LRPInstanceStateUnknown, cc_messages.LRPInstanceReasonCellUnresponsive),
+		Entry("an unrecognized state", "BOGUS", models.ActualLRP_Ordinary, "", int32(0), cc_messages.LRPInstanceStateUnknown, cc_messages.LRPInstanceReasonUnrecognizedState),
+	)
+})
@@ -0,0 +1,137 @@
+package cc_messages
+
+import (
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+const (
+	LRPInstanceDetailsEvacuating = "evacuating"
+	LRPInstanceDetailsSuspect    = "cell is unresponsive"
+)
+
+// LRPInstancesFromActualLRPGroups maps the ActualLRP groups of a single
+// process into the LRPInstances reported to the CC, ordered by index.
+//
+// Each group resolves to its ordinary instance when that instance is running
+// or crashed and to its evacuating instance otherwise. A resolved instance
+// whose cell has gone suspect is reported as UNKNOWN. Every index below
+// desiredInstances without a group gets a DOWN placeholder. stats, keyed by
+// instance guid, may be nil.
+func LRPInstancesFromActualLRPGroups(
+	processGuid string,
+	desiredInstances int,
+	groups []*models.ActualLRPGroup,
+	stats map[string]*LRPInstanceStats,
+	now time.Time,
+) []LRPInstance {
+	instances := make([]LRPInstance, 0, len(groups))
+	seen := make(map[int32]bool, len(groups))
+
+	for _, group := range groups {
+		actual, evacuating := resolveActualLRPGroup(group)
+		if actual == nil {
+			continue
+		}
+
+		instance := LRPInstanceFromActualLRP(actual, now)
+		if evacuating && instance.Reason == "" {
+			instance.Reason = LRPInstanceReasonEvacuating
+			instance.Details = lrpInstanceDetails(instance.Reason, actual)
+		}
+		instance.Stats = stats[actual.InstanceGuid]
+
+		seen[actual.Index] = true
+		instances = append(instances, instance)
+	}
+
+	for index := 0; index < desiredInstances; index++ {
+		if seen[int32(index)] {
+			continue
+		}
+
+		instances = append(instances, LRPInstance{
+			ProcessGuid: processGuid,
+			Index:       uint(index),
+			State:       LRPInstanceStateDown,
+			Reason:      LRPInstanceReasonMissing,
+		})
+	}
+
+	sort.SliceStable(instances, func(i, j int) bool {
+		return instances[i].Index < instances[j].Index
+	})
+
+	return instances
+}
+
+// LRPInstanceFromActualLRP maps a single ActualLRP into an LRPInstance.
+// Since and Uptime are reported in seconds; Uptime is only set for running
+// instances.
+func LRPInstanceFromActualLRP(actual *models.ActualLRP, now time.Time) LRPInstance {
+	state, reason := DeriveLRPInstanceState(actual, models.DefaultMaxRestarts)
+
+	instance := LRPInstance{
+		ProcessGuid:  actual.ProcessGuid,
+		InstanceGuid: actual.InstanceGuid,
+		Index:        uint(actual.Index),
+		State:        state,
+		Reason:       reason,
+		Details:      lrpInstanceDetails(reason, actual),
+		Host:         actual.Address,
+		NetInfo:      actual.ActualLRPNetInfo,
+		Since:        actual.Since / int64(time.Second),
+	}
+
+	if len(actual.Ports) > 0 {
+		instance.Port = uint16(actual.Ports[0].HostPort)
+	}
+
+	if instance.State == LRPInstanceStateRunning {
+		instance.Uptime = (now.UnixNano() - actual.Since) / int64(time.Second)
+	}
+
+	return instance
+}
+
+// lrpInstanceDetails returns the LRPInstance.Details text for reason: the
+// ActualLRP's own explanation where bbs records one, a fixed description
+// otherwise, and nothing without a reason.
+func lrpInstanceDetails(reason LRPInstanceReason, actual *models.ActualLRP) string {
+	switch reason {
+	case LRPInstanceReasonPlacementFailed:
+		return actual.PlacementError
+	case LRPInstanceReasonCrashed, LRPInstanceReasonCrashLimitReached:
+		return actual.CrashReason
+	case LRPInstanceReasonEvacuating:
+		return LRPInstanceDetailsEvacuating
+	case LRPInstanceReasonCellUnresponsive:
+		return LRPInstanceDetailsSuspect
+	default:
+		return ""
+	}
+}
+
+// LRPInstanceStateFromActualLRP derives the instance state with bbs's
+// default restart limit.
+func LRPInstanceStateFromActualLRP(actual *models.ActualLRP) LRPInstanceState {
+	state, _ := DeriveLRPInstanceState(actual, models.DefaultMaxRestarts)
+	return state
+}
+
+func resolveActualLRPGroup(group *models.ActualLRPGroup) (*models.ActualLRP, bool) {
+	switch {
+	case group == nil:
+		return nil, false
+	case group.Evacuating == nil:
+		return group.Instance, false
+	case group.Instance == nil:
+		return group.Evacuating, true
+	case group.Instance.State == models.ActualLRPStateRunning || group.Instance.State == models.ActualLRPStateCrashed:
+		return group.Instance, false
+	default:
+		return group.Evacuating, true
+	}
+}
@@ -0,0 +1,211 @@
+package cc_messages_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LRPInstanceTranslator", func() {
+	var (
+		now   time.Time
+		since int64
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1000, 0)
+		since = time.Unix(900, 0).UnixNano()
+	})
+
+	actualLRP := func(index int32, instanceGuid, state string) *models.ActualLRP {
+		return &models.ActualLRP{
+			ActualLRPKey:         models.NewActualLRPKey("process-guid", index, "domain"),
+			ActualLRPInstanceKey: models.NewActualLRPInstanceKey(instanceGuid, "cell-id"),
+			ActualLRPNetInfo:     models.NewActualLRPNetInfo("1.2.3.4", "2.2.2.2", models.ActualLRPNetInfo_PreferredAddressUnknown, models.NewPortMapping(61000, 8080)),
+			State:                state,
+			Since:                since,
+		}
+	}
+
+	Describe("LRPInstanceFromActualLRP", func() {
+		It("maps the instance's identity, address and timing", func() {
+			actual := actualLRP(1, "instance-guid", models.ActualLRPStateRunning)
+
+			Expect(cc_messages.LRPInstanceFromActualLRP(actual, now)).To(Equal(cc_messages.LRPInstance{
+				ProcessGuid:  "process-guid",
+				InstanceGuid: "instance-guid",
+				Index:        1,
+				State:        cc_messages.LRPInstanceStateRunning,
+				Host:         "1.2.3.4",
+				Port:         61000,
+				NetInfo:      actual.ActualLRPNetInfo,
+				Uptime:       100,
+				Since:        900,
+			}))
+		})
+
+		It("only reports uptime for running instances", func() {
+			actual := actualLRP(0, "instance-guid", models.ActualLRPStateClaimed)
+			Expect(cc_messages.LRPInstanceFromActualLRP(actual, now).Uptime).To(BeZero())
+		})
+
+		It("reports the placement error of unclaimed instances", func() {
+			actual := actualLRP(0, "", models.ActualLRPStateUnclaimed)
+			actual.PlacementError = "insufficient resources"
+
+			instance := cc_messages.LRPInstanceFromActualLRP(actual, now)
+			Expect(instance.Details).To(Equal("insufficient resources"))
+			Expect(instance.Reason).To(Equal(cc_messages.LRPInstanceReasonPlacementFailed))
+		})
+
+		It("only sets details alongside a reason", func() {
+			actual := actualLRP(0, "instance-guid", models.ActualLRPStateRunning)
+			actual.PlacementError = "stale placement error"
+			actual.CrashReason = "stale crash reason"
+
+			instance := cc_messages.LRPInstanceFromActualLRP(actual, now)
+			Expect(instance.Reason).To(BeEmpty())
+			Expect(instance.Details).To(BeEmpty())
+		})
+
+		It("reports the crash reason of crashed instances", func() {
+			actual := actualLRP(0, "", models.ActualLRPStateCrashed)
+			actual.CrashReason = "exit status 1"
+
+			instance := cc_messages.LRPInstanceFromActualLRP(actual, now)
+			Expect(instance.Details).To(Equal("exit status 1"))
+			Expect(instance.Reason).To(Equal(cc_messages.LRPInstanceReasonCrashed))
+		})
+
+		It("reports instances past the restart limit as DOWN", func() {
+			actual := actualLRP(0, "", models.ActualLRPStateCrashed)
+			actual.CrashCount = models.DefaultMaxRestarts
+			actual.CrashReason = "exit status 1"
+
+			instance := cc_messages.LRPInstanceFromActualLRP(actual, now)
+			Expect(instance.State).To(Equal(cc_messages.LRPInstanceStateDown))
+			Expect(instance.Reason).To(Equal(cc_messages.LRPInstanceReasonCrashLimitReached))
+			Expect(instance.Details).To(Equal("exit status 1"))
+		})
+
+		It("leaves the port empty when no ports are mapped", func() {
+			actual := actualLRP(0, "instance-guid", models.ActualLRPStateRunning)
+			actual.Ports = nil
+
+			Expect(cc_messages.LRPInstanceFromActualLRP(actual, now).Port).To(BeZero())
+		})
+	})
+
+	DescribeTable("LRPInstanceStateFromActualLRP",
+		func(state string, presence models.ActualLRP_Presence, expected cc_messages.LRPInstanceState) {
+			actual := actualLRP(0, "instance-guid", state)
+			actual.Presence = presence
+
+			Expect(cc_messages.LRPInstanceStateFromActualLRP(actual)).To(Equal(expected))
+		},
+		Entry("unclaimed", models.ActualLRPStateUnclaimed, models.ActualLRP_Ordinary, cc_messages.LRPInstanceStateStarting),
+		Entry("claimed", models.ActualLRPStateClaimed, models.ActualLRP_Ordinary, cc_messages.LRPInstanceStateStarting),
+		Entry("running", models.ActualLRPStateRunning, models.ActualLRP_Ordinary, cc_messages.LRPInstanceStateRunning),
+		Entry("crashed", models.ActualLRPStateCrashed, models.ActualLRP_Ordinary, cc_messages.LRPInstanceStateCrashed),
+		Entry("an unrecognized state", "BOGUS", models.ActualLRP_Ordinary, cc_messages.LRPInstanceStateUnknown),
+		Entry("suspect", models.ActualLRPStateRunning, models.ActualLRP_Suspect, cc_messages.LRPInstanceStateUnknown),
+	)
+
+	Describe("LRPInstancesFromActualLRPGroups", func() {
+		It("orders instances by index and fills desired indices that are missing with DOWN", func() {
+			groups := []*models.ActualLRPGroup{
+				{Instance: actualLRP(2, "instance-2", models.ActualLRPStateRunning)},
+				{Instance: actualLRP(0, "instance-0", models.ActualLRPStateRunning)},
+			}
+
+			instances := cc_messages.LRPInstancesFromActualLRPGroups("process-guid", 4, groups, nil, now)
+			Expect(instances).To(HaveLen(4))
+
+			Expect(instances[0].InstanceGuid).To(Equal("instance-0"))
+			Expect(instances[1]).To(Equal(cc_messages.LRPInstance{
+				ProcessGuid: "process-guid",
+				Index:       1,
+				State:       cc_messages.LRPInstanceStateDown,
+				Reason:      cc_messages.LRPInstanceReasonMissing,
+			}))
+			Expect(instances[2].InstanceGuid).To(Equal("instance-2"))
+			Expect(instances[3].Index).To(BeEquivalentTo(3))
+			Expect(instances[3].State).To(Equal(cc_messages.LRPInstanceStateDown))
+		})
+
+		It("keeps instances beyond the desired count", func() {
+			groups := []*models.ActualLRPGroup{
+				{Instance: actualLRP(1, "instance-1", models.ActualLRPStateRunning)},
+			}
+
+			instances := cc_messages.LRPInstancesFromActualLRPGroups("process-guid", 1, groups, nil, now)
+			Expect(instances).To(HaveLen(2))
+			Expect(instances[0].State).To(Equal(cc_messages.LRPInstanceStateDown))
+			Expect(instances[1].InstanceGuid).To(Equal("instance-1"))
+		})
+
+		It("reports the evacuating instance until the replacement is running", func() {
+			groups := []*models.ActualLRPGroup{
+				{
+					Instance:   actualLRP(0, "replacement", models.ActualLRPStateClaimed),
+					Evacuating: actualLRP(0, "evacuating", models.ActualLRPStateRunning),
+				},
+				{
+					Instance:   actualLRP(1, "replacement", models.ActualLRPStateRunning),
+					Evacuating: actualLRP(1, "evacuating", models.ActualLRPStateRunning),
+				},
+				{Evacuating: actualLRP(2, "evacuating", models.ActualLRPStateRunning)},
+			}
+
+			instances := cc_messages.LRPInstancesFromActualLRPGroups("process-guid", 3, groups, nil, now)
+			Expect(instances).To(HaveLen(3))
+
+			Expect(instances[0].InstanceGuid).To(Equal("evacuating"))
+			Expect(instances[0].State).To(Equal(cc_messages.LRPInstanceStateRunning))
+			Expect(instances[0].Details).To(Equal(cc_messages.LRPInstanceDetailsEvacuating))
+			Expect(instances[0].Reason).To(Equal(cc_messages.LRPInstanceReasonEvacuating))
+
+			Expect(instances[1].InstanceGuid).To(Equal("replacement"))
+			Expect(instances[1].Details).To(BeEmpty())
+
+			Expect(instances[2].InstanceGuid).To(Equal("evacuating"))
+		})
+
+		It("reports suspect instances as UNKNOWN", func() {
+			suspect := actualLRP(0, "suspect", models.ActualLRPStateRunning)
+			suspect.Presence = models.ActualLRP_Suspect
+
+			instances := cc_messages.LRPInstancesFromActualLRPGroups("process-guid", 1, []*models.ActualLRPGroup{{Instance: suspect}}, nil, now)
+			Expect(instances).To(HaveLen(1))
+			Expect(instances[0].State).To(Equal(cc_messages.LRPInstanceStateUnknown))
+			Expect(instances[0].Details).To(Equal(cc_messages.LRPInstanceDetailsSuspect))
+			Expect(instances[0].Reason).To(Equal(cc_messages.LRPInstanceReasonCellUnresponsive))
+			Expect(instances[0].Uptime).To(BeZero())
+		})
+
+		It("attaches container metrics by instance guid", func() {
+			stats := &cc_messages.LRPInstanceStats{Time: now, CpuPercentage: 0.5, MemoryBytes: 1024, DiskBytes: 2048}
+			groups := []*models.ActualLRPGroup{
+				{Instance: actualLRP(0, "instance-0", models.ActualLRPStateRunning)},
+				{Instance: actualLRP(1, "instance-1", models.ActualLRPStateRunning)},
+			}
+
+			instances := cc_messages.LRPInstancesFromActualLRPGroups("process-guid", 2, groups, map[string]*cc_messages.LRPInstanceStats{
+				"instance-0": stats,
+			}, now)
+			Expect(instances[0].Stats).To(Equal(stats))
+			Expect(instances[1].Stats).To(BeNil())
+		})
+
+		It("skips empty groups", func() {
+			instances := cc_messages.LRPInstancesFromActualLRPGroups("process-guid", 1, []*models.ActualLRPGroup{{}, nil}, nil, now)
+			Expect(instances).To(HaveLen(1))
+			Expect(instances[0].State).To(Equal(cc_messages.LRPInstanceStateDown))
+		})
+	})
+})
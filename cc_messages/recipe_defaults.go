@@ -0,0 +1,14 @@
+package cc_messages
+
+// Diego fills in these values when the CC leaves the corresponding fields of
+// a desired app out, and runs the lifecycle binaries from LifecyclePath. The
+// recipebuilder builds with them, and DesireAppRequestFromDesiredLRP and
+// GenerateVcapApplication rely on them to match what the container runs.
+const (
+	DefaultAppPort            = uint32(8080)
+	DefaultAppFileDescriptors = uint64(1024)
+
+	LifecyclePath = "/tmp/lifecycle"
+	DiegoSSHDPath = LifecyclePath + "/diego-sshd"
+	DiegoSSHPort  = uint32(2222)
+)
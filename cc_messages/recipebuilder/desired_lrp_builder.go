@@ -0,0 +1,283 @@
+package recipebuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+const HealthCheckTimeout = 10 * time.Minute
+
+var ErrAppSourceMissing = errors.New("desired app missing both droplet_uri and docker_image; exactly one is required")
+
+// DesiredLRPBuilder translates a DesireAppRequestFromCC into the
+// DesiredLRP run in the AppLRPDomain.
+type DesiredLRPBuilder struct {
+	config Config
+}
+
+func NewDesiredLRPBuilder(config Config) *DesiredLRPBuilder {
+	return &DesiredLRPBuilder{config: config}
+}
+
+// appLifecycle holds the parts of a DesiredLRP that differ between the
+// buildpack and docker lifecycles.
+type appLifecycle struct {
+	name          string
+	rootFS        string
+	user          string
+	privileged    bool
+	setup         models.ActionInterface
+	ports         []uint32
+	imageUsername string
+	imagePassword string
+}
+
+func (b *DesiredLRPBuilder) Build(desiredApp *cc_messages.DesireAppRequestFromCC) (*models.DesiredLRP, error) {
+	if desiredApp.DropletUri != "" && desiredApp.DockerImageUrl != "" {
+		return nil, ErrMultipleAppSources
+	}
+
+	var lifecycle appLifecycle
+	var err error
+	switch {
+	case desiredApp.DockerImageUrl != "":
+		lifecycle, err = b.dockerLifecycle(desiredApp)
+	case desiredApp.DropletUri != "":
+		lifecycle, err = b.buildpackLifecycle(desiredApp)
+	default:
+		err = ErrAppSourceMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lifecycleDependency, err := b.config.lifecycleDependency(lifecycle.name)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeMounts, err := convertVolumeMounts(desiredApp.VolumeMounts)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := desiredApp.Ports
+	if len(ports) == 0 {
+		ports = lifecycle.ports
+	}
+
+	numFiles := DefaultFileDescriptorLimit
+	if desiredApp.FileDescriptors != 0 {
+		numFiles = desiredApp.FileDescriptors
+	}
+
+	env, err := cc_messages.MergeEnvironment(nil, desiredApp.Environment, []*models.EnvironmentVariable{
+		{Name: "PORT", Value: strconv.FormatUint(uint64(ports[0]), 10)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []models.ActionInterface{&models.RunAction{
+		User:           lifecycle.user,
+		Path:           LifecyclePath + "/launcher",
+		Args:           []string{"app", desiredApp.StartCommand, desiredApp.ExecutionMetadata},
+		Env:            env,
+		LogSource:      logSource(desiredApp.LogSource, AppLogSource),
+		ResourceLimits: resourceLimits(numFiles),
+	}}
+
+	var routes *models.Routes
+	if desiredApp.RoutingInfo != nil {
+		desiredRoutes := models.Routes{}
+		for key, payload := range desiredApp.RoutingInfo {
+			desiredRoutes[key] = payload
+		}
+		routes = &desiredRoutes
+	}
+
+	monitor := monitorAction(desiredApp, ports, lifecycle.user, numFiles)
+
+	if desiredApp.AllowSSH {
+		sshd, sshRoute, err := b.sshAction(lifecycle.user, env, numFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, sshd)
+		if routes == nil {
+			routes = &models.Routes{}
+		}
+		(*routes)[cc_messages.CC_SSH_ROUTES] = sshRoute
+		ports = append(ports[:len(ports):len(ports)], DefaultSSHPort)
+	}
+
+	return &models.DesiredLRP{
+		ProcessGuid: desiredApp.ProcessGuid,
+		Domain:      cc_messages.AppLRPDomain,
+		Instances:   int32(desiredApp.NumInstances),
+		RootFs:      lifecycle.rootFS,
+		Privileged:  lifecycle.privileged,
+
+		MemoryMb:  int32(desiredApp.MemoryMB),
+		DiskMb:    int32(desiredApp.DiskMB),
+		CpuWeight: cpuWeight(desiredApp.MemoryMB),
+
+		Ports:  ports,
+		Routes: routes,
+
+		LogGuid:     desiredApp.LogGuid,
+		LogSource:   LRPLogSource,
+		MetricsGuid: desiredApp.LogGuid,
+		Annotation:  desiredApp.ETag,
+
+		EnvironmentVariables: []*models.EnvironmentVariable{{Name: "LANG", Value: DefaultLANG}},
+		CachedDependencies:   []*models.CachedDependency{lifecycleDependency},
+
+		Setup:          models.WrapAction(lifecycle.setup),
+		Action:         models.WrapAction(models.Codependent(actions...)),
+		Monitor:        models.WrapAction(monitor),
+		StartTimeoutMs: int64(desiredApp.HealthCheckTimeoutInSeconds) * 1000,
+
+		EgressRules:                   desiredApp.EgressRules,
+		Network:                       desiredApp.Network,
+		VolumeMounts:                  volumeMounts,
+		PlacementTags:                 placementTags(desiredApp.IsolationSegment),
+		TrustedSystemCertificatesPath: TrustedSystemCertificatesPath,
+
+		ImageUsername: lifecycle.imageUsername,
+		ImagePassword: lifecycle.imagePassword,
+	}, nil
+}
+
+func (b *DesiredLRPBuilder) buildpackLifecycle(desiredApp *cc_messages.DesireAppRequestFromCC) (appLifecycle, error) {
+	rootFS, err := b.config.rootFS(desiredApp.Stack)
+	if err != nil {
+		return appLifecycle{}, err
+	}
+
+	return appLifecycle{
+		name:       cc_messages.BUILDPACK_LIFECYCLE + "/" + desiredApp.Stack,
+		rootFS:     rootFS,
+		user:       BuildpackUser,
+		privileged: b.config.PrivilegedContainers,
+		setup:      models.Serial(dropletDownloadAction(desiredApp.DropletUri, desiredApp.DropletHash, "droplets-"+desiredApp.ProcessGuid)),
+		ports:      []uint32{DefaultPort},
+	}, nil
+}
+
+func (b *DesiredLRPBuilder) dockerLifecycle(desiredApp *cc_messages.DesireAppRequestFromCC) (appLifecycle, error) {
+	rootFS, err := convertDockerURI(desiredApp.DockerImageUrl)
+	if err != nil {
+		return appLifecycle{}, err
+	}
+
+	var executionMetadata DockerExecutionMetadata
+	if desiredApp.ExecutionMetadata != "" {
+		err = json.Unmarshal([]byte(desiredApp.ExecutionMetadata), &executionMetadata)
+		if err != nil {
+			return appLifecycle{}, fmt.Errorf("invalid docker execution metadata: %s", err)
+		}
+	}
+
+	user := executionMetadata.User
+	if user == "" {
+		user = DockerUser
+	}
+
+	var ports []uint32
+	for _, port := range executionMetadata.ExposedPorts {
+		if port.Protocol == "tcp" {
+			ports = append(ports, port.Port)
+		}
+	}
+	if len(ports) == 0 {
+		ports = []uint32{DefaultPort}
+	}
+
+	password, err := b.config.dockerPassword(desiredApp.RegistryCredentials)
+	if err != nil {
+		return appLifecycle{}, err
+	}
+
+	return appLifecycle{
+		name:          cc_messages.DOCKER_LIFECYCLE,
+		rootFS:        rootFS,
+		user:          user,
+		ports:         ports,
+		imageUsername: desiredApp.DockerUser,
+		imagePassword: password,
+	}, nil
+}
+
+// sshAction runs diego-sshd next to the app, as nsync does, and returns the
+// diego-ssh route the ssh proxy uses to reach it.
+func (b *DesiredLRPBuilder) sshAction(user string, env []*models.EnvironmentVariable, numFiles uint64) (*models.RunAction, *json.RawMessage, error) {
+	hostKeyPair, err := b.config.sshKeyFactory().NewKeyPair(DefaultSSHKeyBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	userKeyPair, err := b.config.sshKeyFactory().NewKeyPair(DefaultSSHKeyBits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sshd := &models.RunAction{
+		User: user,
+		Path: cc_messages.DiegoSSHDPath,
+		Args: []string{
+			fmt.Sprintf("-address=0.0.0.0:%d", DefaultSSHPort),
+			"-hostKey=" + hostKeyPair.PEMEncodedPrivateKey(),
+			"-authorizedKey=" + userKeyPair.AuthorizedKey(),
+			"-inheritDaemonEnv",
+			"-logLevel=fatal",
+		},
+		Env:            env,
+		ResourceLimits: resourceLimits(numFiles),
+	}
+
+	payload, err := json.Marshal(cc_messages.CCSSHRoute{
+		ContainerPort:   DefaultSSHPort,
+		PrivateKey:      userKeyPair.PEMEncodedPrivateKey(),
+		HostFingerprint: hostKeyPair.Fingerprint(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sshRoute := json.RawMessage(payload)
+	return sshd, &sshRoute, nil
+}
+
+func monitorAction(desiredApp *cc_messages.DesireAppRequestFromCC, ports []uint32, user string, numFiles uint64) models.ActionInterface {
+	var checks []models.ActionInterface
+
+	switch desiredApp.HealthCheckType {
+	case cc_messages.NoneHealthCheckType:
+		return nil
+	case cc_messages.HTTPHealthCheckType:
+		checks = append(checks, healthCheckAction(user, numFiles, fmt.Sprintf("-port=%d", ports[0]), "-uri="+desiredApp.HealthCheckHTTPEndpoint))
+	default:
+		for _, port := range ports {
+			checks = append(checks, healthCheckAction(user, numFiles, fmt.Sprintf("-port=%d", port)))
+		}
+	}
+
+	return models.Timeout(models.Parallel(checks...), HealthCheckTimeout)
+}
+
+func healthCheckAction(user string, numFiles uint64, args ...string) *models.RunAction {
+	return &models.RunAction{
+		User:              user,
+		Path:              LifecyclePath + "/healthcheck",
+		Args:              args,
+		LogSource:         HealthLogSource,
+		ResourceLimits:    resourceLimits(numFiles),
+		SuppressLogOutput: true,
+	}
+}
@@ -0,0 +1,37 @@
+package recipebuilder
+
+import "code.cloudfoundry.org/runtimeschema/cc_messages"
+
+const DockerScheme = cc_messages.DockerImageScheme
+
+var ErrDockerImageInvalid = cc_messages.ErrDockerImageScheme
+
+// DockerExecutionMetadata is the execution metadata the docker lifecycle
+// records for an image at staging time.
+type DockerExecutionMetadata struct {
+	Cmd          []string `json:"cmd,omitempty"`
+	Entrypoint   []string `json:"entrypoint,omitempty"`
+	Workdir      string   `json:"workdir,omitempty"`
+	ExposedPorts []Port   `json:"ports,omitempty"`
+	User         string   `json:"user,omitempty"`
+}
+
+type Port struct {
+	Port     uint32
+	Protocol string
+}
+
+// convertDockerURI turns a docker image reference such as "diego/image:tag"
+// into the docker:// rootfs URI garden expects. Valid URIs already in
+// docker:// form are returned unchanged.
+func convertDockerURI(dockerURI string) (string, error) {
+	ref, err := cc_messages.ParseDockerImageReference(dockerURI)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.Scheme == DockerScheme {
+		return dockerURI, nil
+	}
+	return ref.URI(), nil
+}
@@ -0,0 +1 @@
+package recipebuilder // import "code.cloudfoundry.org/runtimeschema/cc_messages/recipebuilder"
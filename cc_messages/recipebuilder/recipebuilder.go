@@ -0,0 +1,216 @@
+package recipebuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/flags"
+)
+
+const (
+	MinCpuProxy = 256
+	MaxCpuProxy = 8192
+
+	DefaultFileDescriptorLimit = cc_messages.DefaultAppFileDescriptors
+	DefaultLANG                = "en_US.UTF-8"
+	DefaultPort                = cc_messages.DefaultAppPort
+
+	BuildpackUser = "vcap"
+	DockerUser    = "root"
+
+	LifecyclePath                 = cc_messages.LifecyclePath
+	TrustedSystemCertificatesPath = "/etc/cf-system-certificates"
+
+	StaticRoute = "/v1/static/"
+
+	LRPLogSource    = "CELL"
+	AppLogSource    = "APP"
+	HealthLogSource = "HEALTH"
+	TaskLogSource   = "TASK"
+)
+
+var (
+	ErrNoLifecycleDefined = errors.New("no lifecycle binary bundle defined for stack")
+	ErrNoRootFSDefined    = errors.New("no rootfs defined for stack")
+	ErrMultipleAppSources = errors.New("desired app contains both droplet_uri and docker_image; exactly one is required")
+)
+
+// Config holds the deployment specific settings shared by the builders.
+type Config struct {
+	// Lifecycles maps lifecycle names ("buildpack/<stack>", "docker") to the
+	// path or URL of their binary bundle.
+	Lifecycles flags.LifecycleMap
+
+	// RootFSes maps stacks to the rootfs their containers run on, e.g.
+	// "cflinuxfs3" to "preloaded:cflinuxfs3".
+	RootFSes map[string]string
+
+	// FileServerURL is used to build the download URL of lifecycle bundles
+	// given as paths.
+	FileServerURL string
+
+	// PrivilegedContainers runs buildpack apps in privileged containers.
+	PrivilegedContainers bool
+
+	// StagerURL receives the completion callback of staging tasks.
+	StagerURL string
+
+	// CCUploaderURL proxies droplet and build artifacts cache uploads to
+	// the CC.
+	CCUploaderURL string
+
+	// DockerStagingStack is the stack whose rootfs docker staging runs on.
+	DockerStagingStack string
+
+	// SkipCertVerify disables TLS verification when the builder downloads
+	// buildpacks and images.
+	SkipCertVerify bool
+
+	// SSHKeyFactory generates the keys of apps that allow ssh. It defaults
+	// to RSAKeyFactory.
+	SSHKeyFactory SSHKeyFactory
+
+	// CredentialKeyring seals the plaintext docker passwords of messages
+	// before they are written into bbs records. Without it plaintext
+	// passwords are written as they are. Sealed passwords are passed
+	// through either way; see SealedCredential.
+	CredentialKeyring cc_messages.CredentialKeyring
+}
+
+func (c Config) lifecycleURL(lifecycle string) (string, error) {
+	lifecyclePath, ok := c.Lifecycles[lifecycle]
+	if !ok {
+		return "", ErrNoLifecycleDefined
+	}
+
+	if strings.HasPrefix(lifecyclePath, "http://") || strings.HasPrefix(lifecyclePath, "https://") {
+		return lifecyclePath, nil
+	}
+
+	return strings.TrimRight(c.FileServerURL, "/") + StaticRoute + strings.TrimLeft(lifecyclePath, "/"), nil
+}
+
+func (c Config) sshKeyFactory() SSHKeyFactory {
+	if c.SSHKeyFactory == nil {
+		return RSAKeyFactory{}
+	}
+	return c.SSHKeyFactory
+}
+
+// dockerPassword returns the password written into bbs records: the sealed
+// password, sealing a plaintext one when there is a CredentialKeyring,
+// encoded with SealedCredential.Encode for the cell to open. Only a
+// plaintext password without a keyring is returned as it is.
+func (c Config) dockerPassword(credentials cc_messages.RegistryCredentials) (string, error) {
+	sealed := credentials.SealedDockerPassword
+	if sealed == nil {
+		if credentials.DockerPassword == "" || c.CredentialKeyring == nil {
+			return credentials.DockerPassword, nil
+		}
+
+		var err error
+		sealed, err = c.CredentialKeyring.Seal([]byte(credentials.DockerPassword))
+		if err != nil {
+			return "", err
+		}
+	}
+	return sealed.Encode()
+}
+
+func (c Config) rootFS(stack string) (string, error) {
+	rootFS, ok := c.RootFSes[stack]
+	if !ok {
+		return "", ErrNoRootFSDefined
+	}
+	return rootFS, nil
+}
+
+func (c Config) lifecycleDependency(lifecycle string) (*models.CachedDependency, error) {
+	lifecycleURL, err := c.lifecycleURL(lifecycle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CachedDependency{
+		From:     lifecycleURL,
+		To:       LifecyclePath,
+		CacheKey: strings.Replace(lifecycle, "/", "-", 1) + "-lifecycle",
+	}, nil
+}
+
+func dropletDownloadAction(dropletUri, dropletHash, cacheKey string) *models.DownloadAction {
+	download := &models.DownloadAction{
+		From:     dropletUri,
+		To:       ".",
+		CacheKey: cacheKey,
+		User:     BuildpackUser,
+	}
+	if dropletHash != "" {
+		download.ChecksumAlgorithm = "sha1"
+		download.ChecksumValue = dropletHash
+	}
+	return download
+}
+
+func cpuWeight(memoryMB int) uint32 {
+	cpuProxy := memoryMB
+
+	if cpuProxy > MaxCpuProxy {
+		return 100
+	}
+
+	if cpuProxy < MinCpuProxy {
+		return 1
+	}
+
+	return uint32(99.0*(cpuProxy-MinCpuProxy)/(MaxCpuProxy-MinCpuProxy) + 1)
+}
+
+func resourceLimits(fileDescriptors uint64) *models.ResourceLimits {
+	limits := &models.ResourceLimits{}
+	limits.SetNofile(fileDescriptors)
+	return limits
+}
+
+func placementTags(isolationSegment string) []string {
+	if isolationSegment == "" {
+		return nil
+	}
+	return []string{isolationSegment}
+}
+
+func logSource(source, defaultSource string) string {
+	if source == "" {
+		return defaultSource
+	}
+	return source
+}
+
+func convertVolumeMounts(volumeMounts []*cc_messages.VolumeMount) ([]*models.VolumeMount, error) {
+	var mounts []*models.VolumeMount
+	for _, volumeMount := range volumeMounts {
+		mountConfig := ""
+		if len(volumeMount.Device.MountConfig) > 0 {
+			mountConfigJson, err := json.Marshal(volumeMount.Device.MountConfig)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mount config for volume %s: %s", volumeMount.Device.VolumeId, err)
+			}
+			mountConfig = string(mountConfigJson)
+		}
+
+		mounts = append(mounts, &models.VolumeMount{
+			Driver:       volumeMount.Driver,
+			ContainerDir: volumeMount.ContainerDir,
+			Mode:         volumeMount.Mode,
+			Shared: &models.SharedDevice{
+				VolumeId:    volumeMount.Device.VolumeId,
+				MountConfig: mountConfig,
+			},
+		})
+	}
+	return mounts, nil
+}
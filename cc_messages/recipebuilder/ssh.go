@@ -0,0 +1,103 @@
+package recipebuilder
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+const (
+	DefaultSSHPort    = cc_messages.DiegoSSHPort
+	DefaultSSHKeyBits = 2048
+
+	sshRSAKeyType = "ssh-rsa"
+)
+
+// SSHKeyPair is a key pair for diego-sshd: the host key it serves and the
+// user key the ssh proxy authenticates with.
+type SSHKeyPair interface {
+	PEMEncodedPrivateKey() string
+
+	// AuthorizedKey is the public key in authorized_keys format.
+	AuthorizedKey() string
+
+	// Fingerprint is the MD5 fingerprint of the public key, as colon
+	// separated hex.
+	Fingerprint() string
+}
+
+type SSHKeyFactory interface {
+	NewKeyPair(bits int) (SSHKeyPair, error)
+}
+
+// RSAKeyFactory generates RSA key pairs.
+type RSAKeyFactory struct{}
+
+func (RSAKeyFactory) NewKeyPair(bits int) (SSHKeyPair, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, err
+	}
+	return rsaKeyPair{privateKey: privateKey}, nil
+}
+
+type rsaKeyPair struct {
+	privateKey *rsa.PrivateKey
+}
+
+func (k rsaKeyPair) PEMEncodedPrivateKey() string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(k.privateKey),
+	}))
+}
+
+func (k rsaKeyPair) AuthorizedKey() string {
+	return sshRSAKeyType + " " + base64.StdEncoding.EncodeToString(k.publicKeyWireFormat()) + "\n"
+}
+
+func (k rsaKeyPair) Fingerprint() string {
+	sum := md5.Sum(k.publicKeyWireFormat())
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// publicKeyWireFormat encodes the public key as described in RFC 4253,
+// section 6.6.
+func (k rsaKeyPair) publicKeyWireFormat() []byte {
+	var buffer bytes.Buffer
+	writeSSHString(&buffer, []byte(sshRSAKeyType))
+	writeSSHString(&buffer, sshMPInt(big.NewInt(int64(k.privateKey.E))))
+	writeSSHString(&buffer, sshMPInt(k.privateKey.N))
+	return buffer.Bytes()
+}
+
+func writeSSHString(buffer *bytes.Buffer, value []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(value)))
+	buffer.Write(length[:])
+	buffer.Write(value)
+}
+
+// sshMPInt encodes a non-negative integer as an RFC 4251 mpint, without its
+// length.
+func sshMPInt(n *big.Int) []byte {
+	value := n.Bytes()
+	if len(value) > 0 && value[0]&0x80 != 0 {
+		value = append([]byte{0}, value...)
+	}
+	return value
+}
@@ -0,0 +1,335 @@
+package recipebuilder
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+const (
+	StagingLogSource     = "STG"
+	StagingTaskCpuWeight = uint32(50)
+
+	DefaultStagingTimeout = 15 * time.Minute
+
+	DropletUploadPath        = "/v1/droplet/"
+	BuildArtifactsUploadPath = "/v1/build_artifacts/"
+
+	stagingBuildDir             = "/tmp/app"
+	stagingBuildpacksDir        = "/tmp/buildpacks"
+	stagingOutputDroplet        = "/tmp/droplet"
+	stagingOutputMetadata       = "/tmp/result.json"
+	stagingBuildArtifactsCache  = "/tmp/cache"
+	stagingOutputArtifactsCache = "/tmp/output-cache"
+	stagingDockerOutputMetadata = "/tmp/docker-result/result.json"
+)
+
+// UnsupportedLifecycleError is returned for lifecycle data the staging task
+// builder cannot build, e.g. CNBStagingData, which the stager has no
+// backend for.
+type UnsupportedLifecycleError struct {
+	Lifecycle string
+}
+
+func (e UnsupportedLifecycleError) Error() string {
+	if e.Lifecycle == "" {
+		return "staging task builder requires lifecycle data"
+	}
+	return fmt.Sprintf("staging task builder does not support the %q lifecycle", e.Lifecycle)
+}
+
+// StagingTaskBuilder translates a StagingRequestFromCC and its decoded
+// lifecycle data into the TaskDefinition run in the StagingTaskDomain.
+type StagingTaskBuilder struct {
+	config Config
+}
+
+func NewStagingTaskBuilder(config Config) *StagingTaskBuilder {
+	return &StagingTaskBuilder{config: config}
+}
+
+// stagingLifecycle holds the parts of a staging TaskDefinition that differ
+// between the buildpack and docker lifecycles.
+type stagingLifecycle struct {
+	rootFS     string
+	privileged bool
+	resultFile string
+	dependency *models.CachedDependency
+	action     models.ActionInterface
+}
+
+// BuildDesireTaskRequest wraps the built TaskDefinition in the request that
+// desires it in the StagingTaskDomain.
+func (b *StagingTaskBuilder) BuildDesireTaskRequest(stagingGuid string, request *cc_messages.StagingRequestFromCC, lifecycleData cc_messages.LifecycleData) (*models.DesireTaskRequest, error) {
+	taskDefinition, err := b.Build(stagingGuid, request, lifecycleData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.DesireTaskRequest{
+		TaskDefinition: taskDefinition,
+		TaskGuid:       stagingGuid,
+		Domain:         cc_messages.StagingTaskDomain,
+	}, nil
+}
+
+func (b *StagingTaskBuilder) Build(stagingGuid string, request *cc_messages.StagingRequestFromCC, lifecycleData cc_messages.LifecycleData) (*models.TaskDefinition, error) {
+	timeout := DefaultStagingTimeout
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Second
+	}
+
+	numFiles := DefaultFileDescriptorLimit
+	if request.FileDescriptors > 0 {
+		numFiles = uint64(request.FileDescriptors)
+	}
+
+	var lifecycle stagingLifecycle
+	var err error
+	switch data := lifecycleData.(type) {
+	case cc_messages.BuildpackStagingData:
+		lifecycle, err = b.buildpackLifecycle(request, data, numFiles, timeout)
+	case cc_messages.DockerStagingData:
+		lifecycle, err = b.dockerLifecycle(request, data, numFiles)
+	case nil:
+		err = UnsupportedLifecycleError{}
+	default:
+		err = UnsupportedLifecycleError{Lifecycle: data.LifecycleName()}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	annotationJson, err := json.Marshal(cc_messages.StagingTaskAnnotation{
+		Lifecycle:          lifecycleData.LifecycleName(),
+		CompletionCallback: request.CompletionCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TaskDefinition{
+		RootFs:     lifecycle.rootFS,
+		Privileged: lifecycle.privileged,
+		ResultFile: lifecycle.resultFile,
+
+		MemoryMb:  int32(request.MemoryMB),
+		DiskMb:    int32(request.DiskMB),
+		CpuWeight: StagingTaskCpuWeight,
+
+		LogGuid:     request.LogGuid,
+		LogSource:   StagingLogSource,
+		MetricsGuid: request.LogGuid,
+
+		EnvironmentVariables: []*models.EnvironmentVariable{{Name: "LANG", Value: DefaultLANG}},
+		CachedDependencies:   []*models.CachedDependency{lifecycle.dependency},
+		Action:               models.WrapAction(models.Timeout(lifecycle.action, timeout)),
+
+		CompletionCallbackUrl: strings.TrimRight(b.config.StagerURL, "/") + "/v1/staging/" + stagingGuid + "/completed",
+		Annotation:            string(annotationJson),
+
+		EgressRules:                   request.EgressRules,
+		PlacementTags:                 placementTags(request.IsolationSegment),
+		TrustedSystemCertificatesPath: TrustedSystemCertificatesPath,
+	}, nil
+}
+
+func (b *StagingTaskBuilder) buildpackLifecycle(request *cc_messages.StagingRequestFromCC, data cc_messages.BuildpackStagingData, numFiles uint64, timeout time.Duration) (stagingLifecycle, error) {
+	lifecycleDependency, err := b.config.lifecycleDependency(cc_messages.BUILDPACK_LIFECYCLE + "/" + data.Stack)
+	if err != nil {
+		return stagingLifecycle{}, err
+	}
+
+	rootFS, err := b.config.rootFS(data.Stack)
+	if err != nil {
+		return stagingLifecycle{}, err
+	}
+
+	dropletUploadURL, err := b.uploadURL(DropletUploadPath, request, timeout, cc_messages.CcDropletUploadUriKey, data.DropletUploadUri)
+	if err != nil {
+		return stagingLifecycle{}, err
+	}
+
+	var buildpackKeys []string
+	downloads := []models.ActionInterface{}
+	for _, buildpack := range data.Buildpacks {
+		buildpackKeys = append(buildpackKeys, buildpack.Key)
+		if buildpack.Name == cc_messages.CUSTOM_BUILDPACK {
+			continue
+		}
+
+		downloads = append(downloads, &models.DownloadAction{
+			Artifact: buildpack.Name,
+			From:     buildpack.Url,
+			To:       buildpackPath(buildpack.Key),
+			CacheKey: buildpack.Key,
+			User:     BuildpackUser,
+		})
+	}
+
+	if data.BuildArtifactsCacheDownloadUri != "" {
+		downloads = append(downloads, models.Try(&models.DownloadAction{
+			Artifact: "build artifacts cache",
+			From:     data.BuildArtifactsCacheDownloadUri,
+			To:       stagingBuildArtifactsCache,
+			User:     BuildpackUser,
+		}))
+	}
+
+	skipDetect := len(data.Buildpacks) == 1 && data.Buildpacks[0].SkipDetect
+
+	uploads := []models.ActionInterface{
+		models.EmitProgressFor(&models.UploadAction{
+			Artifact: "droplet",
+			From:     stagingOutputDroplet,
+			To:       dropletUploadURL,
+			User:     BuildpackUser,
+		}, "Uploading droplet...", "Uploaded droplet", "Uploading droplet failed"),
+	}
+
+	if data.BuildArtifactsCacheUploadUri != "" {
+		buildArtifactsUploadURL, err := b.uploadURL(BuildArtifactsUploadPath, request, timeout, cc_messages.CcBuildArtifactsUploadUriKey, data.BuildArtifactsCacheUploadUri)
+		if err != nil {
+			return stagingLifecycle{}, err
+		}
+
+		uploads = append(uploads, models.Try(&models.UploadAction{
+			Artifact: "build artifacts cache",
+			From:     stagingOutputArtifactsCache,
+			To:       buildArtifactsUploadURL,
+			User:     BuildpackUser,
+		}))
+	}
+
+	env, err := cc_messages.MergeEnvironment(request.Environment, nil, []*models.EnvironmentVariable{
+		{Name: "CF_STACK", Value: data.Stack},
+	})
+	if err != nil {
+		return stagingLifecycle{}, err
+	}
+
+	actions := []models.ActionInterface{
+		models.EmitProgressFor(&models.DownloadAction{
+			Artifact: "app package",
+			From:     data.AppBitsDownloadUri,
+			To:       stagingBuildDir,
+			User:     BuildpackUser,
+		}, "", "", "Downloading app package failed"),
+	}
+	if len(downloads) > 0 {
+		actions = append(actions, models.Parallel(downloads...))
+	}
+	actions = append(actions,
+		models.EmitProgressFor(&models.RunAction{
+			User: BuildpackUser,
+			Path: LifecyclePath + "/builder",
+			Args: []string{
+				"-buildDir=" + stagingBuildDir,
+				"-buildpacksDir=" + stagingBuildpacksDir,
+				"-outputDroplet=" + stagingOutputDroplet,
+				"-outputMetadata=" + stagingOutputMetadata,
+				"-buildArtifactsCacheDir=" + stagingBuildArtifactsCache,
+				"-outputBuildArtifactsCache=" + stagingOutputArtifactsCache,
+				"-buildpackOrder=" + strings.Join(buildpackKeys, ","),
+				"-skipDetect=" + strconv.FormatBool(skipDetect),
+				"-skipCertVerify=" + strconv.FormatBool(b.config.SkipCertVerify),
+			},
+			Env:            env,
+			ResourceLimits: resourceLimits(numFiles),
+		}, "Staging...", "Staging complete", "Staging failed"),
+		models.Parallel(uploads...),
+	)
+
+	return stagingLifecycle{
+		rootFS:     rootFS,
+		privileged: b.config.PrivilegedContainers,
+		resultFile: stagingOutputMetadata,
+		dependency: lifecycleDependency,
+		action:     models.Serial(actions...),
+	}, nil
+}
+
+func (b *StagingTaskBuilder) dockerLifecycle(request *cc_messages.StagingRequestFromCC, data cc_messages.DockerStagingData, numFiles uint64) (stagingLifecycle, error) {
+	lifecycleDependency, err := b.config.lifecycleDependency(cc_messages.DOCKER_LIFECYCLE)
+	if err != nil {
+		return stagingLifecycle{}, err
+	}
+
+	rootFS, err := b.config.rootFS(b.config.DockerStagingStack)
+	if err != nil {
+		return stagingLifecycle{}, err
+	}
+
+	args := []string{
+		"-outputMetadataJSONFilename=" + stagingDockerOutputMetadata,
+		"-dockerRef=" + data.DockerImageUrl,
+	}
+	if data.DockerLoginServer != "" {
+		args = append(args, "-dockerRegistryAddress="+data.DockerLoginServer)
+	}
+	if data.DockerUser != "" {
+		password, err := b.config.dockerPassword(data.RegistryCredentials)
+		if err != nil {
+			return stagingLifecycle{}, err
+		}
+		args = append(args, "-dockerUser="+data.DockerUser, "-dockerPassword="+password)
+	}
+	if data.DockerEmail != "" {
+		args = append(args, "-dockerEmail="+data.DockerEmail)
+	}
+
+	env, err := cc_messages.MergeEnvironment(request.Environment, nil, nil)
+	if err != nil {
+		return stagingLifecycle{}, err
+	}
+
+	return stagingLifecycle{
+		rootFS:     rootFS,
+		resultFile: stagingDockerOutputMetadata,
+		dependency: lifecycleDependency,
+		action: models.EmitProgressFor(&models.RunAction{
+			User:           BuildpackUser,
+			Path:           LifecyclePath + "/builder",
+			Args:           args,
+			Env:            env,
+			ResourceLimits: resourceLimits(numFiles),
+		}, "Staging...", "Staging complete", "Staging failed"),
+	}, nil
+}
+
+// uploadURL points an upload at the CC uploader, which forwards it to the
+// CC URL given under ccKey once the staging task completes, within the
+// staging task's effective timeout.
+func (b *StagingTaskBuilder) uploadURL(uploadPath string, request *cc_messages.StagingRequestFromCC, timeout time.Duration, ccKey, ccURL string) (string, error) {
+	uploader, err := url.Parse(b.config.CCUploaderURL)
+	if err != nil {
+		return "", err
+	}
+
+	ccUploadURL, err := url.Parse(ccURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %s", ccKey, err)
+	}
+	ccUploadURL.User = nil
+
+	values := url.Values{}
+	values.Set(ccKey, ccUploadURL.String())
+	values.Set(cc_messages.CcTimeoutKey, strconv.FormatInt(int64(timeout/time.Second), 10))
+
+	uploader.Path = strings.TrimRight(uploader.Path, "/") + uploadPath + request.AppId
+	uploader.RawQuery = values.Encode()
+	return uploader.String(), nil
+}
+
+func buildpackPath(key string) string {
+	sum := md5.Sum([]byte(key))
+	return stagingBuildpacksDir + "/" + hex.EncodeToString(sum[:])
+}
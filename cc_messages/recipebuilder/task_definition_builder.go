@@ -0,0 +1,117 @@
+package recipebuilder
+
+import (
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+// TaskDefinitionBuilder translates a TaskRequestFromCC into the
+// TaskDefinition run in the RunningTaskDomain.
+type TaskDefinitionBuilder struct {
+	config Config
+}
+
+func NewTaskDefinitionBuilder(config Config) *TaskDefinitionBuilder {
+	return &TaskDefinitionBuilder{config: config}
+}
+
+// BuildDesireTaskRequest wraps the built TaskDefinition in the request that
+// desires it in the RunningTaskDomain.
+func (b *TaskDefinitionBuilder) BuildDesireTaskRequest(task *cc_messages.TaskRequestFromCC) (*models.DesireTaskRequest, error) {
+	taskDefinition, err := b.Build(task)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.DesireTaskRequest{
+		TaskDefinition: taskDefinition,
+		TaskGuid:       task.TaskGuid,
+		Domain:         cc_messages.RunningTaskDomain,
+	}, nil
+}
+
+// Build validates task and translates it. Docker tasks run DockerPath as
+// their rootfs; every other lifecycle downloads DropletUri onto the rootfs
+// of the RootFs stack.
+func (b *TaskDefinitionBuilder) Build(task *cc_messages.TaskRequestFromCC) (*models.TaskDefinition, error) {
+	err := task.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	volumeMounts, err := convertVolumeMounts(task.VolumeMounts)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := cc_messages.MergeEnvironment(nil, task.EnvironmentVariables, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &models.RunAction{
+		Path:           LifecyclePath + "/launcher",
+		Args:           []string{"app", task.Command, "{}"},
+		Env:            env,
+		LogSource:      logSource(task.LogSource, TaskLogSource),
+		ResourceLimits: resourceLimits(DefaultFileDescriptorLimit),
+	}
+
+	taskDefinition := &models.TaskDefinition{
+		MemoryMb:  int32(task.MemoryMb),
+		DiskMb:    int32(task.DiskMb),
+		CpuWeight: cpuWeight(task.MemoryMb),
+
+		LogGuid:     task.LogGuid,
+		LogSource:   logSource(task.LogSource, TaskLogSource),
+		MetricsGuid: task.LogGuid,
+
+		EnvironmentVariables:  []*models.EnvironmentVariable{{Name: "LANG", Value: DefaultLANG}},
+		CompletionCallbackUrl: task.CompletionCallbackUrl,
+
+		EgressRules:                   task.EgressRules,
+		VolumeMounts:                  volumeMounts,
+		PlacementTags:                 placementTags(task.IsolationSegment),
+		TrustedSystemCertificatesPath: TrustedSystemCertificatesPath,
+	}
+
+	var lifecycle string
+	if task.Lifecycle == cc_messages.DOCKER_LIFECYCLE {
+		lifecycle = cc_messages.DOCKER_LIFECYCLE
+
+		taskDefinition.RootFs, err = convertDockerURI(task.DockerPath)
+		if err != nil {
+			return nil, err
+		}
+
+		run.User = DockerUser
+		taskDefinition.Action = models.WrapAction(run)
+		taskDefinition.ImageUsername = task.DockerUser
+		taskDefinition.ImagePassword, err = b.config.dockerPassword(task.RegistryCredentials)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		lifecycle = task.Lifecycle + "/" + task.RootFs
+
+		taskDefinition.RootFs, err = b.config.rootFS(task.RootFs)
+		if err != nil {
+			return nil, err
+		}
+
+		run.User = BuildpackUser
+		taskDefinition.Action = models.WrapAction(models.Serial(
+			dropletDownloadAction(task.DropletUri, task.DropletHash, ""),
+			run,
+		))
+		taskDefinition.Privileged = b.config.PrivilegedContainers
+	}
+
+	lifecycleDependency, err := b.config.lifecycleDependency(lifecycle)
+	if err != nil {
+		return nil, err
+	}
+	taskDefinition.CachedDependencies = []*models.CachedDependency{lifecycleDependency}
+
+	return taskDefinition, nil
+}
@@ -0,0 +1,256 @@
+package recipebuilder_test
+
+import (
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/flags"
+	"code.cloudfoundry.org/runtimeschema/cc_messages/recipebuilder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TaskDefinitionBuilder", func() {
+	var (
+		builder *recipebuilder.TaskDefinitionBuilder
+		config  recipebuilder.Config
+		task    *cc_messages.TaskRequestFromCC
+		limits  *models.ResourceLimits
+	)
+
+	buildpackTask := func() *cc_messages.TaskRequestFromCC {
+		return &cc_messages.TaskRequestFromCC{
+			Lifecycle:   cc_messages.BUILDPACK_LIFECYCLE,
+			DropletUri:  "http://the-droplet.uri.com",
+			DropletHash: "droplet-hash",
+			RootFs:      "some-stack",
+		}
+	}
+
+	dockerTask := func() *cc_messages.TaskRequestFromCC {
+		return &cc_messages.TaskRequestFromCC{
+			Lifecycle:  cc_messages.DOCKER_LIFECYCLE,
+			DockerPath: "docker:///user/repo#tag",
+			RegistryCredentials: cc_messages.RegistryCredentials{
+				DockerUser:     "docker-user",
+				DockerPassword: "docker-password",
+			},
+		}
+	}
+
+	withCommonFields := func(task *cc_messages.TaskRequestFromCC) *cc_messages.TaskRequestFromCC {
+		task.TaskGuid = "task-guid"
+		task.LogGuid = "log-guid"
+		task.LogSource = "APP/TASK/migrate"
+		task.MemoryMb = 256
+		task.DiskMb = 1024
+		task.Command = "rake db:migrate"
+		task.CompletionCallbackUrl = "http://api.cc.com/v1/tasks/complete"
+		task.IsolationSegment = "segment"
+		task.EnvironmentVariables = []*models.EnvironmentVariable{{Name: "FOO", Value: "BAR"}}
+		task.EgressRules = []*models.SecurityGroupRule{
+			{Protocol: "TCP", Destinations: []string{"0.0.0.0/0"}, PortRange: &models.PortRange{Start: 80, End: 443}},
+		}
+		task.VolumeMounts = []*cc_messages.VolumeMount{
+			{
+				Driver:       "nfs",
+				ContainerDir: "/data",
+				Mode:         "r",
+				Device:       cc_messages.SharedDevice{VolumeId: "volume-id"},
+			},
+		}
+		return task
+	}
+
+	runAction := func(user string) *models.RunAction {
+		return &models.RunAction{
+			User:           user,
+			Path:           "/tmp/lifecycle/launcher",
+			Args:           []string{"app", "rake db:migrate", "{}"},
+			Env:            []*models.EnvironmentVariable{{Name: "FOO", Value: "BAR"}},
+			LogSource:      "APP/TASK/migrate",
+			ResourceLimits: limits,
+		}
+	}
+
+	BeforeEach(func() {
+		config = recipebuilder.Config{
+			Lifecycles: flags.LifecycleMap{
+				"buildpack/some-stack": "some-lifecycle.tgz",
+				"docker":               "docker-lifecycle.tgz",
+			},
+			RootFSes: map[string]string{
+				"some-stack": "preloaded:some-stack",
+			},
+			FileServerURL: "http://file-server.com/",
+		}
+
+		limits = &models.ResourceLimits{}
+		limits.SetNofile(recipebuilder.DefaultFileDescriptorLimit)
+	})
+
+	JustBeforeEach(func() {
+		builder = recipebuilder.NewTaskDefinitionBuilder(config)
+	})
+
+	DescribeTable("fields shared by every lifecycle",
+		func(newTask func() *cc_messages.TaskRequestFromCC) {
+			task = withCommonFields(newTask())
+
+			taskDefinition, err := builder.Build(task)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDefinition.MemoryMb).To(BeEquivalentTo(256))
+			Expect(taskDefinition.DiskMb).To(BeEquivalentTo(1024))
+			Expect(taskDefinition.CpuWeight).To(BeEquivalentTo(1))
+			Expect(taskDefinition.LogGuid).To(Equal("log-guid"))
+			Expect(taskDefinition.MetricsGuid).To(Equal("log-guid"))
+			Expect(taskDefinition.LogSource).To(Equal("APP/TASK/migrate"))
+			Expect(taskDefinition.CompletionCallbackUrl).To(Equal("http://api.cc.com/v1/tasks/complete"))
+			Expect(taskDefinition.EgressRules).To(Equal(task.EgressRules))
+			Expect(taskDefinition.PlacementTags).To(Equal([]string{"segment"}))
+			Expect(taskDefinition.TrustedSystemCertificatesPath).To(Equal(recipebuilder.TrustedSystemCertificatesPath))
+			Expect(taskDefinition.EnvironmentVariables).To(ConsistOf(&models.EnvironmentVariable{Name: "LANG", Value: recipebuilder.DefaultLANG}))
+			Expect(taskDefinition.VolumeMounts).To(Equal([]*models.VolumeMount{
+				{
+					Driver:       "nfs",
+					ContainerDir: "/data",
+					Mode:         "r",
+					Shared:       &models.SharedDevice{VolumeId: "volume-id"},
+				},
+			}))
+			Expect(taskDefinition.CachedDependencies).To(HaveLen(1))
+			Expect(taskDefinition.CachedDependencies[0].To).To(Equal("/tmp/lifecycle"))
+		},
+		Entry("buildpack", buildpackTask),
+		Entry("docker", dockerTask),
+	)
+
+	Context("with the buildpack lifecycle", func() {
+		BeforeEach(func() {
+			task = withCommonFields(buildpackTask())
+		})
+
+		It("downloads the droplet and runs the command on the stack's rootfs", func() {
+			taskDefinition, err := builder.Build(task)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDefinition.RootFs).To(Equal("preloaded:some-stack"))
+			Expect(taskDefinition.ImageUsername).To(BeEmpty())
+			Expect(taskDefinition.CachedDependencies[0]).To(Equal(&models.CachedDependency{
+				From:     "http://file-server.com/v1/static/some-lifecycle.tgz",
+				To:       "/tmp/lifecycle",
+				CacheKey: "buildpack-some-stack-lifecycle",
+			}))
+			Expect(taskDefinition.Action.GetValue()).To(Equal(models.Serial(
+				&models.DownloadAction{
+					From:              "http://the-droplet.uri.com",
+					To:                ".",
+					User:              "vcap",
+					ChecksumAlgorithm: "sha1",
+					ChecksumValue:     "droplet-hash",
+				},
+				runAction("vcap"),
+			)))
+		})
+
+		It("errors when the stack has no rootfs", func() {
+			task.RootFs = "other-stack"
+			_, err := builder.Build(task)
+			Expect(err).To(Equal(recipebuilder.ErrNoRootFSDefined))
+		})
+	})
+
+	Context("with the docker lifecycle", func() {
+		BeforeEach(func() {
+			task = withCommonFields(dockerTask())
+		})
+
+		It("runs the command in the image", func() {
+			taskDefinition, err := builder.Build(task)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDefinition.RootFs).To(Equal("docker:///user/repo#tag"))
+			Expect(taskDefinition.Privileged).To(BeFalse())
+			Expect(taskDefinition.ImageUsername).To(Equal("docker-user"))
+			Expect(taskDefinition.ImagePassword).To(Equal("docker-password"))
+			Expect(taskDefinition.CachedDependencies[0].CacheKey).To(Equal("docker-lifecycle"))
+			Expect(taskDefinition.Action.GetValue()).To(Equal(runAction("root")))
+		})
+
+		Context("when the docker password is sealed", func() {
+			var keyring *cc_messages.AESGCMKeyring
+
+			BeforeEach(func() {
+				var err error
+				keyring, err = cc_messages.NewAESGCMKeyring("key-1", map[string][]byte{"key-1": make([]byte, 32)})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(task.SealDockerPassword(keyring)).To(Succeed())
+			})
+
+			It("passes it through sealed for the cell to open", func() {
+				taskDefinition, err := builder.Build(task)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(taskDefinition.ImagePassword).To(HavePrefix(cc_messages.EncodedSealedCredentialPrefix))
+				Expect(taskDefinition.ImagePassword).NotTo(ContainSubstring("docker-password"))
+
+				password, err := cc_messages.OpenCredential(keyring, taskDefinition.ImagePassword)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(password).To(Equal("docker-password"))
+			})
+		})
+
+		Context("when a keyring is configured", func() {
+			var keyring *cc_messages.AESGCMKeyring
+
+			BeforeEach(func() {
+				var err error
+				keyring, err = cc_messages.NewAESGCMKeyring("key-1", map[string][]byte{"key-1": make([]byte, 32)})
+				Expect(err).NotTo(HaveOccurred())
+				config.CredentialKeyring = keyring
+				builder = recipebuilder.NewTaskDefinitionBuilder(config)
+			})
+
+			It("seals a plaintext password before writing it", func() {
+				taskDefinition, err := builder.Build(task)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(taskDefinition.ImagePassword).To(HavePrefix(cc_messages.EncodedSealedCredentialPrefix))
+
+				password, err := cc_messages.OpenCredential(keyring, taskDefinition.ImagePassword)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(password).To(Equal("docker-password"))
+			})
+		})
+	})
+
+	It("rejects invalid task requests", func() {
+		task = withCommonFields(buildpackTask())
+		task.DropletUri = ""
+
+		_, err := builder.Build(task)
+		Expect(err).To(BeAssignableToTypeOf(cc_messages.ValidationError{}))
+	})
+
+	It("keeps the last value of duplicate environment variables", func() {
+		task = withCommonFields(buildpackTask())
+		task.EnvironmentVariables = append(task.EnvironmentVariables, &models.EnvironmentVariable{Name: "FOO", Value: "BAZ"})
+
+		taskDefinition, err := builder.Build(task)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskDefinition.Action.GetSerialAction().Actions[1].GetRunAction().Env).To(Equal([]*models.EnvironmentVariable{{Name: "FOO", Value: "BAZ"}}))
+	})
+
+	Describe("BuildDesireTaskRequest", func() {
+		It("desires the task in the running task domain", func() {
+			task = withCommonFields(buildpackTask())
+
+			request, err := builder.BuildDesireTaskRequest(task)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(request.Domain).To(Equal(cc_messages.RunningTaskDomain))
+			Expect(request.TaskGuid).To(Equal("task-guid"))
+			Expect(request.TaskDefinition.RootFs).To(Equal("preloaded:some-stack"))
+		})
+	})
+})
@@ -0,0 +1,342 @@
+package cc_messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// RedactedValue replaces credentials and sensitive values in redacted copies
+// of messages.
+const RedactedValue = "[REDACTED]"
+
+// DefaultSensitiveNamePatterns match the names of environment variables,
+// mount config keys and lifecycle data keys whose values are redacted by
+// DefaultRedactor. Patterns are matched case-insensitively.
+var DefaultSensitiveNamePatterns = []string{
+	"pass",
+	"secret",
+	"token",
+	"_key$",
+	"credential",
+	"^vcap_services$",
+	"^database_url$",
+}
+
+// Redactor produces copies of messages that are safe to log. Docker and
+// registry passwords, including those in lifecycle data, and the diego-ssh
+// private key are always masked; other values are masked when their name
+// matches one of the redactor's patterns. Redacted copies are for
+// logging only and must never be sent to the CC or bbs.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// sshRouteRedactor and lifecycleDataRedactor mask what every Redactor masks
+// regardless of its patterns.
+var (
+	sshRouteRedactor      = MustNewRedactor("^private_key$")
+	lifecycleDataRedactor = MustNewRedactor("^docker_password$", "^sealed_docker_password$", "^password$")
+)
+
+// DefaultRedactor is used by the Redacted, String, GoString and LogValue
+// methods of the messages. Replace it to change what is masked.
+var DefaultRedactor = MustNewRedactor(DefaultSensitiveNamePatterns...)
+
+func NewRedactor(patterns ...string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %s", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+func MustNewRedactor(patterns ...string) *Redactor {
+	r, err := NewRedactor(patterns...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Sensitive reports whether the value named name is masked.
+func (r *Redactor) Sensitive(name string) bool {
+	for _, re := range r.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Environment returns a copy of env with the values of sensitive variables
+// masked.
+func (r *Redactor) Environment(env []*models.EnvironmentVariable) []*models.EnvironmentVariable {
+	if env == nil {
+		return nil
+	}
+
+	redacted := make([]*models.EnvironmentVariable, len(env))
+	for i, envVar := range env {
+		if envVar == nil {
+			continue
+		}
+		copied := *envVar
+		if copied.Value != "" && r.Sensitive(copied.Name) {
+			copied.Value = RedactedValue
+		}
+		redacted[i] = &copied
+	}
+	return redacted
+}
+
+func (r *Redactor) RegistryCredentials(c RegistryCredentials) RegistryCredentials {
+	c.DockerPassword = redactString(c.DockerPassword)
+	return c
+}
+
+func (r *Redactor) DesireAppRequest(d DesireAppRequestFromCC) DesireAppRequestFromCC {
+	d.RegistryCredentials = r.RegistryCredentials(d.RegistryCredentials)
+	d.Environment = r.Environment(d.Environment)
+	d.VolumeMounts = r.volumeMounts(d.VolumeMounts)
+	d.RoutingInfo = r.routingInfo(d.RoutingInfo)
+	return d
+}
+
+func (r *Redactor) TaskRequest(t TaskRequestFromCC) TaskRequestFromCC {
+	t.RegistryCredentials = r.RegistryCredentials(t.RegistryCredentials)
+	t.EnvironmentVariables = r.Environment(t.EnvironmentVariables)
+	t.VolumeMounts = r.volumeMounts(t.VolumeMounts)
+	return t
+}
+
+// StagingRequest masks the environment and every sensitive key of the
+// lifecycle data, at any depth. Docker passwords, sealed or not, and CNB
+// credential passwords in the lifecycle data are masked even when no
+// pattern matches them. Lifecycle data that is not valid JSON is replaced
+// entirely.
+func (r *Redactor) StagingRequest(s StagingRequestFromCC) StagingRequestFromCC {
+	s.Environment = r.Environment(s.Environment)
+	if s.LifecycleData != nil {
+		data := lifecycleDataRedactor.rawJSON(r.rawJSON(*s.LifecycleData))
+		s.LifecycleData = &data
+	}
+	return s
+}
+
+func (r *Redactor) DockerStagingData(d DockerStagingData) DockerStagingData {
+	d.RegistryCredentials = r.RegistryCredentials(d.RegistryCredentials)
+	return d
+}
+
+func (r *Redactor) CNBStagingData(c CNBStagingData) CNBStagingData {
+	if c.Credentials != nil {
+		credentials := make(map[string]CNBCredential, len(c.Credentials))
+		for registry, credential := range c.Credentials {
+			credential.Password = redactString(credential.Password)
+			credentials[registry] = credential
+		}
+		c.Credentials = credentials
+	}
+	return c
+}
+
+func (r *Redactor) volumeMounts(mounts []*VolumeMount) []*VolumeMount {
+	if mounts == nil {
+		return nil
+	}
+
+	redacted := make([]*VolumeMount, len(mounts))
+	for i, mount := range mounts {
+		if mount == nil {
+			continue
+		}
+		copied := *mount
+		copied.Device.MountConfig = r.value(mount.Device.MountConfig).(map[string]interface{})
+		redacted[i] = &copied
+	}
+	return redacted
+}
+
+// routingInfo masks the sensitive keys of every route payload. The private
+// key of the diego-ssh route is masked even when no pattern matches it.
+func (r *Redactor) routingInfo(info CCRouteInfo) CCRouteInfo {
+	if info == nil {
+		return nil
+	}
+
+	redacted := make(CCRouteInfo, len(info))
+	for key, payload := range info {
+		if payload == nil {
+			redacted[key] = nil
+			continue
+		}
+		data := r.rawJSON(*payload)
+		if key == CC_SSH_ROUTES {
+			data = sshRouteRedactor.rawJSON(data)
+		}
+		redacted[key] = &data
+	}
+	return redacted
+}
+
+func (r *Redactor) rawJSON(raw json.RawMessage) json.RawMessage {
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		encoded, _ := json.Marshal(RedactedValue)
+		return encoded
+	}
+
+	encoded, err := json.Marshal(r.value(decoded))
+	if err != nil {
+		encoded, _ = json.Marshal(RedactedValue)
+	}
+	return encoded
+}
+
+// value returns a copy of a decoded JSON value with the values of sensitive
+// keys masked.
+func (r *Redactor) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		redacted := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if r.Sensitive(key) && value != nil {
+				redacted[key] = RedactedValue
+			} else {
+				redacted[key] = r.value(value)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, value := range v {
+			redacted[i] = r.value(value)
+		}
+		return redacted
+	default:
+		return v
+	}
+}
+
+func redactString(s string) string {
+	if s == "" {
+		return s
+	}
+	return RedactedValue
+}
+
+// The unexported aliases below drop the message methods so that formatting
+// a redacted copy does not recurse.
+type (
+	desireAppRequestFromCC DesireAppRequestFromCC
+	taskRequestFromCC      TaskRequestFromCC
+	stagingRequestFromCC   StagingRequestFromCC
+	dockerStagingData      DockerStagingData
+	cnbStagingData         CNBStagingData
+)
+
+func (d DesireAppRequestFromCC) Redacted() DesireAppRequestFromCC {
+	return DefaultRedactor.DesireAppRequest(d)
+}
+
+func (d DesireAppRequestFromCC) String() string {
+	return fmt.Sprintf("%+v", desireAppRequestFromCC(d.Redacted()))
+}
+
+func (d DesireAppRequestFromCC) GoString() string {
+	return redactedGoString("DesireAppRequestFromCC", desireAppRequestFromCC(d.Redacted()))
+}
+
+func (d DesireAppRequestFromCC) LogValue() slog.Value {
+	return slog.AnyValue(desireAppRequestFromCC(d.Redacted()))
+}
+
+func (t TaskRequestFromCC) Redacted() TaskRequestFromCC {
+	return DefaultRedactor.TaskRequest(t)
+}
+
+func (t TaskRequestFromCC) String() string {
+	return fmt.Sprintf("%+v", taskRequestFromCC(t.Redacted()))
+}
+
+func (t TaskRequestFromCC) GoString() string {
+	return redactedGoString("TaskRequestFromCC", taskRequestFromCC(t.Redacted()))
+}
+
+func (t TaskRequestFromCC) LogValue() slog.Value {
+	return slog.AnyValue(taskRequestFromCC(t.Redacted()))
+}
+
+func (s StagingRequestFromCC) Redacted() StagingRequestFromCC {
+	return DefaultRedactor.StagingRequest(s)
+}
+
+func (s StagingRequestFromCC) String() string {
+	redacted := s.Redacted()
+	// print the lifecycle data as JSON rather than as a byte slice
+	var lifecycleData string
+	if redacted.LifecycleData != nil {
+		lifecycleData = string(*redacted.LifecycleData)
+	}
+	redacted.LifecycleData = nil
+	formatted := fmt.Sprintf("%+v", stagingRequestFromCC(redacted))
+	return strings.Replace(formatted, "LifecycleData:<nil>", "LifecycleData:"+lifecycleData, 1)
+}
+
+func (s StagingRequestFromCC) GoString() string {
+	return redactedGoString("StagingRequestFromCC", stagingRequestFromCC(s.Redacted()))
+}
+
+func (s StagingRequestFromCC) LogValue() slog.Value {
+	return slog.AnyValue(stagingRequestFromCC(s.Redacted()))
+}
+
+func (d DockerStagingData) Redacted() DockerStagingData {
+	return DefaultRedactor.DockerStagingData(d)
+}
+
+func (d DockerStagingData) String() string {
+	return fmt.Sprintf("%+v", dockerStagingData(d.Redacted()))
+}
+
+func (d DockerStagingData) GoString() string {
+	return redactedGoString("DockerStagingData", dockerStagingData(d.Redacted()))
+}
+
+func (d DockerStagingData) LogValue() slog.Value {
+	return slog.AnyValue(dockerStagingData(d.Redacted()))
+}
+
+func (c CNBStagingData) Redacted() CNBStagingData {
+	return DefaultRedactor.CNBStagingData(c)
+}
+
+func (c CNBStagingData) String() string {
+	return fmt.Sprintf("%+v", cnbStagingData(c.Redacted()))
+}
+
+func (c CNBStagingData) GoString() string {
+	return redactedGoString("CNBStagingData", cnbStagingData(c.Redacted()))
+}
+
+func (c CNBStagingData) LogValue() slog.Value {
+	return slog.AnyValue(cnbStagingData(c.Redacted()))
+}
+
+// redactedGoString formats an alias with %#v under the exported type name.
+func redactedGoString(typeName string, alias interface{}) string {
+	formatted := fmt.Sprintf("%#v", alias)
+	return "cc_messages." + typeName + formatted[strings.Index(formatted, "{"):]
+}
@@ -0,0 +1,206 @@
+package cc_messages_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redaction", func() {
+	var desireAppRequest cc_messages.DesireAppRequestFromCC
+
+	BeforeEach(func() {
+		desireAppRequest = cc_messages.DesireAppRequestFromCC{
+			ProcessGuid:    "process-guid",
+			DockerImageUrl: "docker:///user/repo",
+			RegistryCredentials: cc_messages.RegistryCredentials{
+				DockerUser:     "user",
+				DockerPassword: "docker-secret",
+			},
+			Environment: []*models.EnvironmentVariable{
+				{Name: "PORT", Value: "8080"},
+				{Name: "DB_PASSWORD", Value: "env-secret"},
+				{Name: "Api_Token", Value: "token-secret"},
+				{Name: "EMPTY_SECRET", Value: ""},
+			},
+			VolumeMounts: []*cc_messages.VolumeMount{{
+				Driver: "smbdriver",
+				Device: cc_messages.SharedDevice{
+					VolumeId:    "volume-id",
+					MountConfig: map[string]interface{}{"source": "//server/share", "password": "mount-secret"},
+				},
+			}},
+		}
+	})
+
+	It("masks passwords and sensitive environment values in the redacted copy", func() {
+		redacted := desireAppRequest.Redacted()
+
+		Expect(redacted.DockerUser).To(Equal("user"))
+		Expect(redacted.DockerPassword).To(Equal(cc_messages.RedactedValue))
+		Expect(redacted.Environment).To(Equal([]*models.EnvironmentVariable{
+			{Name: "PORT", Value: "8080"},
+			{Name: "DB_PASSWORD", Value: cc_messages.RedactedValue},
+			{Name: "Api_Token", Value: cc_messages.RedactedValue},
+			{Name: "EMPTY_SECRET", Value: ""},
+		}))
+		Expect(redacted.VolumeMounts[0].Device.MountConfig).To(Equal(map[string]interface{}{
+			"source":   "//server/share",
+			"password": cc_messages.RedactedValue,
+		}))
+	})
+
+	It("leaves the original untouched", func() {
+		desireAppRequest.Redacted()
+
+		Expect(desireAppRequest.DockerPassword).To(Equal("docker-secret"))
+		Expect(desireAppRequest.Environment[1].Value).To(Equal("env-secret"))
+		Expect(desireAppRequest.VolumeMounts[0].Device.MountConfig["password"]).To(Equal("mount-secret"))
+	})
+
+	It("keeps the wire encoding unchanged", func() {
+		payload, err := json.Marshal(desireAppRequest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(payload)).To(ContainSubstring(`"docker_password":"docker-secret"`))
+		Expect(string(payload)).To(ContainSubstring(`"value":"env-secret"`))
+	})
+
+	It("never formats credentials", func() {
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+			formatted := fmt.Sprintf(verb, desireAppRequest)
+			Expect(formatted).NotTo(ContainSubstring("secret"), verb)
+			Expect(formatted).To(ContainSubstring("process-guid"), verb)
+		}
+		Expect(fmt.Sprintf("%#v", desireAppRequest)).To(HavePrefix("cc_messages.DesireAppRequestFromCC{"))
+	})
+
+	It("never logs credentials", func() {
+		buffer := &bytes.Buffer{}
+		slog.New(slog.NewJSONHandler(buffer, nil)).Info("desiring", "request", desireAppRequest)
+		Expect(buffer.String()).NotTo(ContainSubstring("secret"))
+		Expect(buffer.String()).To(ContainSubstring(`"docker_password":"[REDACTED]"`))
+
+		buffer.Reset()
+		slog.New(slog.NewTextHandler(buffer, nil)).Info("desiring", "request", desireAppRequest)
+		Expect(buffer.String()).NotTo(ContainSubstring("secret"))
+	})
+
+	It("redacts the private key of the ssh route", func() {
+		sshRoute := json.RawMessage(`{"container_port":2222,"private_key":"SECRETKEY"}`)
+		httpRoutes := json.RawMessage(`[{"hostname":"app.example.com"}]`)
+		desiredApp := cc_messages.DesireAppRequestFromCC{
+			ProcessGuid: "process-guid",
+			RoutingInfo: cc_messages.CCRouteInfo{
+				cc_messages.CC_SSH_ROUTES:  &sshRoute,
+				cc_messages.CC_HTTP_ROUTES: &httpRoutes,
+			},
+		}
+
+		redacted := desiredApp.Redacted()
+		Expect(*redacted.RoutingInfo[cc_messages.CC_SSH_ROUTES]).To(MatchJSON(`{"container_port":2222,"private_key":"[REDACTED]"}`))
+		Expect(*redacted.RoutingInfo[cc_messages.CC_HTTP_ROUTES]).To(MatchJSON(httpRoutes))
+		Expect(string(*desiredApp.RoutingInfo[cc_messages.CC_SSH_ROUTES])).To(ContainSubstring("SECRETKEY"))
+
+		Expect(desiredApp.String()).NotTo(ContainSubstring("SECRETKEY"))
+		Expect(fmt.Sprintf("%v %+v %#v", desiredApp, desiredApp, desiredApp)).NotTo(ContainSubstring("SECRETKEY"))
+
+		withoutPatterns := cc_messages.MustNewRedactor()
+		Expect(*withoutPatterns.DesireAppRequest(desiredApp).RoutingInfo[cc_messages.CC_SSH_ROUTES]).To(MatchJSON(`{"container_port":2222,"private_key":"[REDACTED]"}`))
+	})
+
+	It("redacts task requests", func() {
+		task := cc_messages.TaskRequestFromCC{
+			TaskGuid:             "task-guid",
+			RegistryCredentials:  cc_messages.RegistryCredentials{DockerPassword: "docker-secret"},
+			EnvironmentVariables: []*models.EnvironmentVariable{{Name: "AWS_SECRET_ACCESS_KEY", Value: "env-secret"}},
+		}
+
+		Expect(task.Redacted().DockerPassword).To(Equal(cc_messages.RedactedValue))
+		Expect(task.Redacted().EnvironmentVariables[0].Value).To(Equal(cc_messages.RedactedValue))
+		Expect(fmt.Sprintf("%v %#v", task, task)).NotTo(ContainSubstring("secret"))
+	})
+
+	It("redacts staging requests, including their lifecycle data", func() {
+		lifecycleData := json.RawMessage(`{"docker_image":"user/repo","docker_password":"docker-secret","credentials":{"registry":{"username":"u","password":"cnb-secret"}}}`)
+		staging := cc_messages.StagingRequestFromCC{
+			AppId:         "app-id",
+			Environment:   []*models.EnvironmentVariable{{Name: "VCAP_SERVICES", Value: `{"secret":"env-secret"}`}},
+			LifecycleData: &lifecycleData,
+		}
+
+		redacted := staging.Redacted()
+		Expect(redacted.Environment[0].Value).To(Equal(cc_messages.RedactedValue))
+		Expect(*redacted.LifecycleData).To(MatchJSON(`{"docker_image":"user/repo","docker_password":"[REDACTED]","credentials":"[REDACTED]"}`))
+		Expect(string(*staging.LifecycleData)).To(ContainSubstring("docker-secret"))
+
+		Expect(staging.String()).To(ContainSubstring(`"docker_image":"user/repo"`))
+		Expect(fmt.Sprintf("%v %#v", staging, staging)).NotTo(ContainSubstring("secret\""))
+	})
+
+	It("masks lifecycle data passwords whatever the redactor's patterns", func() {
+		lifecycleData := json.RawMessage(`{"docker_password":"docker-secret","sealed_docker_password":{"key_id":"k1"},"credentials":{"registry":{"username":"u","password":"cnb-secret"}}}`)
+		staging := cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData}
+
+		redacted := cc_messages.MustNewRedactor("^only_this$").StagingRequest(staging)
+		Expect(*redacted.LifecycleData).To(MatchJSON(`{"docker_password":"[REDACTED]","sealed_docker_password":"[REDACTED]","credentials":{"registry":{"username":"u","password":"[REDACTED]"}}}`))
+	})
+
+	It("does not mask buildpack keys", func() {
+		lifecycleData := json.RawMessage(`{"buildpacks":[{"name":"ruby","key":"ruby-buildpack-key"}],"api_key":"api-secret"}`)
+		redacted := cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData}.Redacted()
+		Expect(*redacted.LifecycleData).To(MatchJSON(`{"buildpacks":[{"name":"ruby","key":"ruby-buildpack-key"}],"api_key":"[REDACTED]"}`))
+	})
+
+	It("replaces lifecycle data that is not JSON", func() {
+		lifecycleData := json.RawMessage(`docker-secret`)
+		redacted := cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData}.Redacted()
+		Expect(*redacted.LifecycleData).To(MatchJSON(`"[REDACTED]"`))
+	})
+
+	It("redacts staging data", func() {
+		docker := cc_messages.DockerStagingData{
+			DockerImageUrl:      "user/repo",
+			RegistryCredentials: cc_messages.RegistryCredentials{DockerUser: "user", DockerPassword: "docker-secret"},
+		}
+		Expect(docker.Redacted().DockerPassword).To(Equal(cc_messages.RedactedValue))
+		Expect(fmt.Sprintf("%v %#v", docker, docker)).NotTo(ContainSubstring("secret"))
+
+		cnb := cc_messages.CNBStagingData{Credentials: map[string]cc_messages.CNBCredential{
+			"registry": {Username: "user", Password: "cnb-secret"},
+		}}
+		Expect(cnb.Redacted().Credentials["registry"]).To(Equal(cc_messages.CNBCredential{Username: "user", Password: cc_messages.RedactedValue}))
+		Expect(cnb.Credentials["registry"].Password).To(Equal("cnb-secret"))
+	})
+
+	Describe("Redactor", func() {
+		It("matches names case-insensitively against its patterns", func() {
+			redactor, err := cc_messages.NewRedactor("^my_", "private")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(redactor.Sensitive("MY_VALUE")).To(BeTrue())
+			Expect(redactor.Sensitive("a_Private_value")).To(BeTrue())
+			Expect(redactor.Sensitive("DB_PASSWORD")).To(BeFalse())
+		})
+
+		It("uses its own patterns for environment values", func() {
+			redactor := cc_messages.MustNewRedactor("^port$")
+			redacted := redactor.DesireAppRequest(desireAppRequest)
+
+			Expect(redacted.Environment[0].Value).To(Equal(cc_messages.RedactedValue))
+			Expect(redacted.Environment[1].Value).To(Equal("env-secret"))
+			Expect(redacted.DockerPassword).To(Equal(cc_messages.RedactedValue))
+		})
+
+		It("rejects invalid patterns", func() {
+			_, err := cc_messages.NewRedactor("(")
+			Expect(err).To(MatchError(ContainSubstring(`invalid redaction pattern "("`)))
+		})
+	})
+})
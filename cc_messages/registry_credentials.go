@@ -0,0 +1,94 @@
+package cc_messages
+
+// RegistryCredentials authenticate against the registry of a docker image.
+// They are embedded in DesireAppRequestFromCC, TaskRequestFromCC and
+// DockerStagingData, so their fields keep their JSON names at the top level
+// of each message.
+type RegistryCredentials struct {
+	DockerLoginServer    string            `json:"docker_login_server,omitempty"`
+	DockerUser           string            `json:"docker_user,omitempty"`
+	DockerPassword       string            `json:"docker_password,omitempty"`
+	SealedDockerPassword *SealedCredential `json:"sealed_docker_password,omitempty"`
+	DockerEmail          string            `json:"docker_email,omitempty"`
+}
+
+// HasRegistryCredentials reports whether any credential field is set. The
+// name keeps it from reading as a check on the whole message once promoted
+// onto the messages that embed RegistryCredentials.
+func (c RegistryCredentials) HasRegistryCredentials() bool {
+	return c != RegistryCredentials{}
+}
+
+// HasDockerPassword reports whether a docker password is set, sealed or not.
+func (c RegistryCredentials) HasDockerPassword() bool {
+	return c.DockerPassword != "" || c.SealedDockerPassword != nil
+}
+
+// SealDockerPassword moves the plaintext docker password into
+// SealedDockerPassword. It does nothing when there is no plaintext password.
+func (c *RegistryCredentials) SealDockerPassword(keyring CredentialKeyring) error {
+	if c.DockerPassword == "" {
+		return nil
+	}
+
+	sealed, err := keyring.Seal([]byte(c.DockerPassword))
+	if err != nil {
+		return err
+	}
+
+	c.SealedDockerPassword = sealed
+	c.DockerPassword = ""
+	return nil
+}
+
+// UnsealDockerPassword moves the sealed docker password back into
+// DockerPassword. Credentials carrying a plaintext password are left alone.
+func (c *RegistryCredentials) UnsealDockerPassword(keyring CredentialKeyring) error {
+	if c.SealedDockerPassword == nil {
+		return nil
+	}
+
+	password, err := c.OpenDockerPassword(keyring)
+	if err != nil {
+		return err
+	}
+
+	c.DockerPassword = password
+	c.SealedDockerPassword = nil
+	return nil
+}
+
+// OpenDockerPassword returns the plaintext docker password without
+// modifying the credentials. keyring may be nil when the password is not
+// sealed.
+func (c RegistryCredentials) OpenDockerPassword(keyring CredentialKeyring) (string, error) {
+	if c.SealedDockerPassword == nil {
+		return c.DockerPassword, nil
+	}
+	if keyring == nil {
+		return "", ErrNoCredentialKeyring
+	}
+
+	plaintext, err := keyring.Open(c.SealedDockerPassword)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (c RegistryCredentials) validate() ValidationError {
+	var validationError ValidationError
+
+	validationError = validateDockerLoginServer(validationError, c.DockerLoginServer)
+	if c.DockerPassword != "" && c.DockerUser == "" {
+		validationError = validationError.Append("docker_user", "cannot be empty when docker_password is set")
+	}
+	if c.SealedDockerPassword != nil && c.DockerUser == "" {
+		validationError = validationError.Append("docker_user", "cannot be empty when sealed_docker_password is set")
+	}
+	if c.DockerPassword != "" && c.SealedDockerPassword != nil {
+		validationError = validationError.Append("docker_password", "cannot be set together with sealed_docker_password")
+	}
+
+	return validationError
+}
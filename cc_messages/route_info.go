@@ -0,0 +1,140 @@
+package cc_messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrRouteInfoConflict = errors.New("conflicting routes")
+
+// RouteInfoKeyError names the CCRouteInfo key that could not be encoded,
+// decoded or merged.
+type RouteInfoKeyError struct {
+	Key string
+	Err error
+}
+
+func (e RouteInfoKeyError) Error() string {
+	return fmt.Sprintf("Invalid routing info %q: %s", e.Key, e.Err)
+}
+
+// HTTPRoutes decodes the CC_HTTP_ROUTES entry. It returns nil routes when
+// the entry is absent.
+func (r CCRouteInfo) HTTPRoutes() (CCHTTPRoutes, error) {
+	var routes CCHTTPRoutes
+	err := r.decode(CC_HTTP_ROUTES, &routes)
+	if err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// TCPRoutes decodes the CC_TCP_ROUTES entry. It returns nil routes when the
+// entry is absent.
+func (r CCRouteInfo) TCPRoutes() (CCTCPRoutes, error) {
+	var routes CCTCPRoutes
+	err := r.decode(CC_TCP_ROUTES, &routes)
+	if err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+func (r CCRouteInfo) decode(key string, routes interface{}) error {
+	payload := r[key]
+	if payload == nil {
+		return nil
+	}
+
+	err := json.Unmarshal(*payload, routes)
+	if err != nil {
+		return RouteInfoKeyError{Key: key, Err: err}
+	}
+	return nil
+}
+
+// CCRouteInfoBuilder assembles a CCRouteInfo holding several route kinds.
+// Keys it does not know about are carried through unchanged.
+type CCRouteInfoBuilder struct {
+	routeInfo CCRouteInfo
+	err       error
+}
+
+func NewCCRouteInfoBuilder() *CCRouteInfoBuilder {
+	return &CCRouteInfoBuilder{
+		routeInfo: CCRouteInfo{},
+	}
+}
+
+func (b *CCRouteInfoBuilder) WithHTTPRoutes(routes CCHTTPRoutes) *CCRouteInfoBuilder {
+	return b.WithRoutes(CC_HTTP_ROUTES, routes)
+}
+
+func (b *CCRouteInfoBuilder) WithTCPRoutes(routes CCTCPRoutes) *CCRouteInfoBuilder {
+	return b.WithRoutes(CC_TCP_ROUTES, routes)
+}
+
+// WithRoutes encodes routes under key.
+func (b *CCRouteInfoBuilder) WithRoutes(key string, routes interface{}) *CCRouteInfoBuilder {
+	routesJson, err := json.Marshal(routes)
+	if err != nil {
+		b.fail(RouteInfoKeyError{Key: key, Err: err})
+		return b
+	}
+
+	routesPayload := json.RawMessage(routesJson)
+	b.set(key, &routesPayload)
+	return b
+}
+
+// Merge copies every entry of routeInfo, including unknown keys.
+func (b *CCRouteInfoBuilder) Merge(routeInfo CCRouteInfo) *CCRouteInfoBuilder {
+	for key, payload := range routeInfo {
+		b.set(key, payload)
+	}
+	return b
+}
+
+// Build returns the assembled CCRouteInfo, or the first error encountered.
+// Setting the same key twice to different values is an error.
+func (b *CCRouteInfoBuilder) Build() (CCRouteInfo, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	routeInfo := make(CCRouteInfo, len(b.routeInfo))
+	for key, payload := range b.routeInfo {
+		routeInfo[key] = payload
+	}
+	return routeInfo, nil
+}
+
+func (b *CCRouteInfoBuilder) set(key string, payload *json.RawMessage) {
+	existing, found := b.routeInfo[key]
+	if found && !equalPayloads(existing, payload) {
+		b.fail(RouteInfoKeyError{Key: key, Err: ErrRouteInfoConflict})
+		return
+	}
+
+	b.routeInfo[key] = payload
+}
+
+func (b *CCRouteInfoBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func equalPayloads(a, b *json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	var compactA, compactB bytes.Buffer
+	if json.Compact(&compactA, *a) != nil || json.Compact(&compactB, *b) != nil {
+		return bytes.Equal(*a, *b)
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
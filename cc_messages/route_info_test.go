@@ -0,0 +1,113 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+	"math"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CCRouteInfo", func() {
+	rawMessage := func(payload string) *json.RawMessage {
+		message := json.RawMessage(payload)
+		return &message
+	}
+
+	Describe("HTTPRoutes", func() {
+		It("decodes the http routes", func() {
+			routeInfo := cc_messages.CCRouteInfo{
+				cc_messages.CC_HTTP_ROUTES: rawMessage(`[{"hostname": "route1"}, {"hostname": "route2", "port": 8080}]`),
+			}
+
+			routes, err := routeInfo.HTTPRoutes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(Equal(cc_messages.CCHTTPRoutes{
+				{Hostname: "route1"},
+				{Hostname: "route2", Port: 8080},
+			}))
+		})
+
+		It("returns no routes when the key is absent", func() {
+			routes, err := cc_messages.CCRouteInfo{}.HTTPRoutes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(BeNil())
+		})
+
+		It("names the key when the routes are malformed", func() {
+			routeInfo := cc_messages.CCRouteInfo{
+				cc_messages.CC_HTTP_ROUTES: rawMessage(`{"hostname": "route1"}`),
+			}
+
+			_, err := routeInfo.HTTPRoutes()
+			Expect(err).To(BeAssignableToTypeOf(cc_messages.RouteInfoKeyError{}))
+			Expect(err.(cc_messages.RouteInfoKeyError).Key).To(Equal(cc_messages.CC_HTTP_ROUTES))
+		})
+	})
+
+	Describe("TCPRoutes", func() {
+		It("decodes the tcp routes", func() {
+			routeInfo := cc_messages.CCRouteInfo{
+				cc_messages.CC_TCP_ROUTES: rawMessage(`[{"router_group_guid": "group", "external_port": 5222, "container_port": 60000}]`),
+			}
+
+			routes, err := routeInfo.TCPRoutes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routes).To(Equal(cc_messages.CCTCPRoutes{
+				{RouterGroupGuid: "group", ExternalPort: 5222, ContainerPort: 60000},
+			}))
+		})
+	})
+
+	Describe("CCRouteInfoBuilder", func() {
+		It("builds a route info holding several route kinds and unknown keys", func() {
+			httpRoutes := cc_messages.CCHTTPRoutes{{Hostname: "route1"}}
+			tcpRoutes := cc_messages.CCTCPRoutes{{RouterGroupGuid: "group", ExternalPort: 5222}}
+			sshPayload := rawMessage(`{"container_port": 2222}`)
+
+			routeInfo, err := cc_messages.NewCCRouteInfoBuilder().
+				WithHTTPRoutes(httpRoutes).
+				WithTCPRoutes(tcpRoutes).
+				Merge(cc_messages.CCRouteInfo{"diego-ssh": sshPayload}).
+				Build()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(routeInfo).To(HaveLen(3))
+			Expect(routeInfo["diego-ssh"]).To(BeIdenticalTo(sshPayload))
+			Expect(routeInfo.HTTPRoutes()).To(Equal(httpRoutes))
+			Expect(routeInfo.TCPRoutes()).To(Equal(tcpRoutes))
+		})
+
+		It("accepts the same routes from several sources", func() {
+			httpRoutes := cc_messages.CCHTTPRoutes{{Hostname: "route1"}}
+			existing, err := httpRoutes.CCRouteInfo()
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cc_messages.NewCCRouteInfoBuilder().
+				Merge(existing).
+				WithHTTPRoutes(httpRoutes).
+				Build()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("names the key when route kinds conflict", func() {
+			_, err := cc_messages.NewCCRouteInfoBuilder().
+				WithHTTPRoutes(cc_messages.CCHTTPRoutes{{Hostname: "route1"}}).
+				Merge(cc_messages.CCRouteInfo{cc_messages.CC_HTTP_ROUTES: rawMessage(`[]`)}).
+				Build()
+			Expect(err).To(Equal(cc_messages.RouteInfoKeyError{
+				Key: cc_messages.CC_HTTP_ROUTES,
+				Err: cc_messages.ErrRouteInfoConflict,
+			}))
+		})
+
+		It("names the key when routes cannot be encoded", func() {
+			_, err := cc_messages.NewCCRouteInfoBuilder().
+				WithRoutes("broken", math.Inf(1)).
+				Build()
+			Expect(err).To(MatchError(ContainSubstring(`"broken"`)))
+		})
+	})
+})
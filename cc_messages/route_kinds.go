@@ -0,0 +1,161 @@
+package cc_messages
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+const CC_INTERNAL_ROUTES = "internal_routes"
+
+const CC_SSH_ROUTES = "diego-ssh"
+
+type CCInternalRoutes []CCInternalRoute
+
+type CCInternalRoute struct {
+	Hostname string `json:"hostname"`
+}
+
+type CCSSHRoute struct {
+	ContainerPort   uint32 `json:"container_port"`
+	PrivateKey      string `json:"private_key"`
+	HostFingerprint string `json:"host_fingerprint,omitempty"`
+	User            string `json:"user,omitempty"`
+	Password        string `json:"password,omitempty"`
+}
+
+var (
+	ErrRouteKindKeyEmpty          = errors.New("Invalid route kind: empty key")
+	ErrRouteKindRoutesEmpty       = errors.New("Invalid route kind: missing routes type")
+	ErrRouteKindAlreadyRegistered = errors.New("Invalid route kind: already registered")
+)
+
+// RouteKind associates a CCRouteInfo key with the Go type of its routes.
+type RouteKind struct {
+	Key string
+
+	// Routes is a zero value of the type the key's payload decodes into.
+	Routes interface{}
+}
+
+type RouteKindRegistry struct {
+	lock  sync.RWMutex
+	kinds map[string]RouteKind
+}
+
+func NewRouteKindRegistry() *RouteKindRegistry {
+	return &RouteKindRegistry{
+		kinds: map[string]RouteKind{},
+	}
+}
+
+func (r *RouteKindRegistry) Register(kind RouteKind) error {
+	if kind.Key == "" {
+		return ErrRouteKindKeyEmpty
+	}
+
+	if kind.Routes == nil {
+		return ErrRouteKindRoutesEmpty
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, found := r.kinds[kind.Key]; found {
+		return ErrRouteKindAlreadyRegistered
+	}
+
+	r.kinds[kind.Key] = kind
+	return nil
+}
+
+func (r *RouteKindRegistry) Lookup(key string) (RouteKind, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	kind, found := r.kinds[key]
+	return kind, found
+}
+
+// Keys returns the registered route kind keys in sorted order.
+func (r *RouteKindRegistry) Keys() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	keys := make([]string, 0, len(r.kinds))
+	for key := range r.kinds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TypedCCRouteInfo is a CCRouteInfo decoded through a RouteKindRegistry.
+// Routes holds the decoded value of every registered key that was present
+// with a payload; Unknown holds every other key untouched, including
+// registered keys whose payload is nil, so that encoding reproduces them.
+type TypedCCRouteInfo struct {
+	Routes  map[string]interface{}
+	Unknown CCRouteInfo
+}
+
+func (r *RouteKindRegistry) Decode(routeInfo CCRouteInfo) (TypedCCRouteInfo, error) {
+	typed := TypedCCRouteInfo{
+		Routes:  map[string]interface{}{},
+		Unknown: CCRouteInfo{},
+	}
+
+	for key, payload := range routeInfo {
+		kind, found := r.Lookup(key)
+		if !found || payload == nil {
+			typed.Unknown[key] = payload
+			continue
+		}
+
+		routes := newZeroValue(kind.Routes)
+		err := json.Unmarshal(*payload, routes)
+		if err != nil {
+			return TypedCCRouteInfo{}, RouteInfoKeyError{Key: key, Err: err}
+		}
+		typed.Routes[key] = dereference(routes)
+	}
+
+	return typed, nil
+}
+
+// CCRouteInfo encodes the typed routes back into a CCRouteInfo, keeping the
+// unknown keys as they were.
+func (t TypedCCRouteInfo) CCRouteInfo() (CCRouteInfo, error) {
+	builder := NewCCRouteInfoBuilder().Merge(t.Unknown)
+	for key, routes := range t.Routes {
+		builder.WithRoutes(key, routes)
+	}
+	return builder.Build()
+}
+
+// DefaultRouteKindRegistry holds the http, tcp, internal and ssh route kinds.
+var DefaultRouteKindRegistry = NewRouteKindRegistry()
+
+func RegisterRouteKind(kind RouteKind) error {
+	return DefaultRouteKindRegistry.Register(kind)
+}
+
+// Decode decodes every route kind in the DefaultRouteKindRegistry.
+func (r CCRouteInfo) Decode() (TypedCCRouteInfo, error) {
+	return DefaultRouteKindRegistry.Decode(r)
+}
+
+func init() {
+	mustRegisterRouteKind(RouteKind{Key: CC_HTTP_ROUTES, Routes: CCHTTPRoutes{}})
+	mustRegisterRouteKind(RouteKind{Key: CC_TCP_ROUTES, Routes: CCTCPRoutes{}})
+	mustRegisterRouteKind(RouteKind{Key: CC_INTERNAL_ROUTES, Routes: CCInternalRoutes{}})
+	mustRegisterRouteKind(RouteKind{Key: CC_SSH_ROUTES, Routes: CCSSHRoute{}})
+}
+
+func mustRegisterRouteKind(kind RouteKind) {
+	err := RegisterRouteKind(kind)
+	if err != nil {
+		panic(err)
+	}
+}
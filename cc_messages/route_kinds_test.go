@@ -0,0 +1,160 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRoutes []struct {
+	Name string `json:"name"`
+}
+
+var _ = Describe("RouteKinds", func() {
+	rawMessage := func(payload string) *json.RawMessage {
+		message := json.RawMessage(payload)
+		return &message
+	}
+
+	Describe("DefaultRouteKindRegistry", func() {
+		It("contains the http, tcp, internal and ssh route kinds", func() {
+			Expect(cc_messages.DefaultRouteKindRegistry.Keys()).To(Equal([]string{
+				cc_messages.CC_SSH_ROUTES,
+				cc_messages.CC_HTTP_ROUTES,
+				cc_messages.CC_INTERNAL_ROUTES,
+				cc_messages.CC_TCP_ROUTES,
+			}))
+		})
+	})
+
+	Describe("Register", func() {
+		var registry *cc_messages.RouteKindRegistry
+
+		BeforeEach(func() {
+			registry = cc_messages.NewRouteKindRegistry()
+		})
+
+		It("registers the route kind", func() {
+			Expect(registry.Register(cc_messages.RouteKind{Key: "fake", Routes: fakeRoutes{}})).To(Succeed())
+
+			_, found := registry.Lookup("fake")
+			Expect(found).To(BeTrue())
+		})
+
+		It("errors when the key is empty", func() {
+			err := registry.Register(cc_messages.RouteKind{Routes: fakeRoutes{}})
+			Expect(err).To(Equal(cc_messages.ErrRouteKindKeyEmpty))
+		})
+
+		It("errors when the routes type is missing", func() {
+			err := registry.Register(cc_messages.RouteKind{Key: "fake"})
+			Expect(err).To(Equal(cc_messages.ErrRouteKindRoutesEmpty))
+		})
+
+		It("errors when the key is already registered", func() {
+			Expect(registry.Register(cc_messages.RouteKind{Key: "fake", Routes: fakeRoutes{}})).To(Succeed())
+			err := registry.Register(cc_messages.RouteKind{Key: "fake", Routes: fakeRoutes{}})
+			Expect(err).To(Equal(cc_messages.ErrRouteKindAlreadyRegistered))
+		})
+	})
+
+	Describe("Decode", func() {
+		var routeInfo cc_messages.CCRouteInfo
+
+		BeforeEach(func() {
+			routeInfo = cc_messages.CCRouteInfo{
+				cc_messages.CC_HTTP_ROUTES:     rawMessage(`[{"hostname": "route1"}]`),
+				cc_messages.CC_INTERNAL_ROUTES: rawMessage(`[{"hostname": "app.apps.internal"}]`),
+				cc_messages.CC_SSH_ROUTES:      rawMessage(`{"container_port": 2222, "private_key": "key"}`),
+				"some-other-key":               rawMessage(`{"keep": "me"}`),
+			}
+		})
+
+		It("decodes every known route kind and preserves unknown keys", func() {
+			typed, err := routeInfo.Decode()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(typed.Routes).To(Equal(map[string]interface{}{
+				cc_messages.CC_HTTP_ROUTES:     cc_messages.CCHTTPRoutes{{Hostname: "route1"}},
+				cc_messages.CC_INTERNAL_ROUTES: cc_messages.CCInternalRoutes{{Hostname: "app.apps.internal"}},
+				cc_messages.CC_SSH_ROUTES:      cc_messages.CCSSHRoute{ContainerPort: 2222, PrivateKey: "key"},
+			}))
+			Expect(typed.Unknown).To(Equal(cc_messages.CCRouteInfo{
+				"some-other-key": routeInfo["some-other-key"],
+			}))
+		})
+
+		It("decodes kinds registered with a custom registry", func() {
+			registry := cc_messages.NewRouteKindRegistry()
+			Expect(registry.Register(cc_messages.RouteKind{Key: "some-other-key", Routes: map[string]string{}})).To(Succeed())
+
+			typed, err := registry.Decode(routeInfo)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(typed.Routes).To(Equal(map[string]interface{}{
+				"some-other-key": map[string]string{"keep": "me"},
+			}))
+			Expect(typed.Unknown).To(HaveLen(3))
+		})
+
+		It("names the key that failed to decode", func() {
+			routeInfo[cc_messages.CC_TCP_ROUTES] = rawMessage(`"not-routes"`)
+
+			_, err := routeInfo.Decode()
+			Expect(err).To(BeAssignableToTypeOf(cc_messages.RouteInfoKeyError{}))
+			Expect(err.(cc_messages.RouteInfoKeyError).Key).To(Equal(cc_messages.CC_TCP_ROUTES))
+		})
+
+		It("round-trips back into a CCRouteInfo", func() {
+			typed, err := routeInfo.Decode()
+			Expect(err).NotTo(HaveOccurred())
+
+			encoded, err := typed.CCRouteInfo()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encoded).To(HaveLen(len(routeInfo)))
+			for key, payload := range routeInfo {
+				Expect(string(*encoded[key])).To(MatchJSON(string(*payload)))
+			}
+		})
+
+		It("round-trips ssh routes with credentials", func() {
+			routeInfo[cc_messages.CC_SSH_ROUTES] = rawMessage(`{
+				"container_port": 2222,
+				"private_key": "key",
+				"host_fingerprint": "fingerprint",
+				"user": "vcap",
+				"password": "secret"
+			}`)
+
+			typed, err := routeInfo.Decode()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(typed.Routes).To(HaveKeyWithValue(cc_messages.CC_SSH_ROUTES, cc_messages.CCSSHRoute{
+				ContainerPort:   2222,
+				PrivateKey:      "key",
+				HostFingerprint: "fingerprint",
+				User:            "vcap",
+				Password:        "secret",
+			}))
+
+			encoded, err := typed.CCRouteInfo()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(*encoded[cc_messages.CC_SSH_ROUTES])).To(MatchJSON(string(*routeInfo[cc_messages.CC_SSH_ROUTES])))
+		})
+
+		It("keeps registered keys without a payload", func() {
+			routeInfo[cc_messages.CC_TCP_ROUTES] = nil
+
+			typed, err := routeInfo.Decode()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(typed.Routes).NotTo(HaveKey(cc_messages.CC_TCP_ROUTES))
+			Expect(typed.Unknown).To(HaveKeyWithValue(cc_messages.CC_TCP_ROUTES, BeNil()))
+
+			encoded, err := typed.CCRouteInfo()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encoded).To(HaveLen(len(routeInfo)))
+			Expect(encoded).To(HaveKeyWithValue(cc_messages.CC_TCP_ROUTES, BeNil()))
+		})
+	})
+})
@@ -0,0 +1,279 @@
+package cc_messages
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// EncodedSealedCredentialPrefix starts every SealedCredential encoded with
+// Encode, telling it apart from a plaintext credential.
+const EncodedSealedCredentialPrefix = "sealed-credential:"
+
+var (
+	ErrCredentialDecryptionFailed = errors.New("failed to decrypt sealed credential")
+	ErrActiveCredentialKey        = errors.New("cannot remove the active credential key")
+	ErrNoCredentialKeyring        = errors.New("sealed credential requires a credential keyring")
+	ErrNoActiveCredentialKey      = errors.New("credential keyring has no active key")
+	ErrNoSealedCredential         = errors.New("sealed credential cannot be nil")
+	ErrMalformedSealedCredential  = errors.New("malformed encoded sealed credential")
+)
+
+type UnknownCredentialKeyError struct {
+	KeyID string
+}
+
+func (e UnknownCredentialKeyError) Error() string {
+	return fmt.Sprintf("unknown credential key %q", e.KeyID)
+}
+
+// DuplicateCredentialKeyError is returned when a key id is added again with
+// different key bytes, which would orphan everything sealed under it.
+type DuplicateCredentialKeyError struct {
+	KeyID string
+}
+
+func (e DuplicateCredentialKeyError) Error() string {
+	return fmt.Sprintf("credential key %q is already registered with a different key", e.KeyID)
+}
+
+// SealedCredential is a credential encrypted under the keyring key named
+// KeyID. Nonce and Ciphertext are base64 encoded on the wire.
+//
+// Sealing keeps the password out of the messages and of anything that
+// stores them. The recipebuilder never opens it: the DesiredLRP,
+// TaskDefinition and docker staging builder arguments it produces carry
+// the credential as encoded by Encode, and the cell opens it with
+// OpenCredential right before pulling the image.
+type SealedCredential struct {
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Encode returns the credential as a single string, for fields such as
+// DesiredLRP.ImagePassword that can only hold a string.
+func (s SealedCredential) Encode() (string, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return EncodedSealedCredentialPrefix + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// IsEncodedSealedCredential reports whether value was produced by Encode.
+func IsEncodedSealedCredential(value string) bool {
+	return strings.HasPrefix(value, EncodedSealedCredentialPrefix)
+}
+
+func DecodeSealedCredential(value string) (*SealedCredential, error) {
+	if !IsEncodedSealedCredential(value) {
+		return nil, ErrMalformedSealedCredential
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, EncodedSealedCredentialPrefix))
+	if err != nil {
+		return nil, ErrMalformedSealedCredential
+	}
+
+	sealed := &SealedCredential{}
+	err = json.Unmarshal(payload, sealed)
+	if err != nil {
+		return nil, ErrMalformedSealedCredential
+	}
+	return sealed, nil
+}
+
+// OpenCredential returns the plaintext of value: value itself when it is
+// a plaintext credential, and the opened credential when it was encoded
+// with SealedCredential.Encode. keyring may be nil for plaintext values.
+func OpenCredential(keyring CredentialKeyring, value string) (string, error) {
+	if !IsEncodedSealedCredential(value) {
+		return value, nil
+	}
+	if keyring == nil {
+		return "", ErrNoCredentialKeyring
+	}
+
+	sealed, err := DecodeSealedCredential(value)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := keyring.Open(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// CredentialKeyring seals credentials under its active key and opens
+// credentials sealed under any key it still holds.
+type CredentialKeyring interface {
+	Seal(plaintext []byte) (*SealedCredential, error)
+	Open(sealed *SealedCredential) ([]byte, error)
+}
+
+// AESGCMKeyring is a local CredentialKeyring of AES-GCM keys. Keys are
+// rotated by adding a new key and making it active; the old keys stay
+// available for opening until every credential sealed under them has been
+// resealed, after which they can be removed.
+type AESGCMKeyring struct {
+	lock        sync.RWMutex
+	activeKeyID string
+	keys        map[string]aesGCMKey
+}
+
+type aesGCMKey struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+// NewAESGCMKeyring builds a keyring from AES keys of 16, 24 or 32 bytes
+// keyed by their id. activeKeyID must name one of the keys.
+func NewAESGCMKeyring(activeKeyID string, keys map[string][]byte) (*AESGCMKeyring, error) {
+	keyring := &AESGCMKeyring{keys: make(map[string]aesGCMKey, len(keys))}
+	for keyID, key := range keys {
+		err := keyring.AddKey(keyID, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := keyring.Activate(activeKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+func (k *AESGCMKeyring) ActiveKeyID() string {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.activeKeyID
+}
+
+// AddKey makes key available for opening credentials. It does not change
+// the active key. Adding a key id again is only allowed with the same key;
+// otherwise it returns a DuplicateCredentialKeyError.
+func (k *AESGCMKeyring) AddKey(keyID string, key []byte) error {
+	if keyID == "" {
+		return errors.New("credential key id cannot be empty")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid credential key %q: %s", keyID, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("invalid credential key %q: %s", keyID, err)
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if existing, ok := k.keys[keyID]; ok {
+		if subtle.ConstantTimeCompare(existing.key, key) != 1 {
+			return DuplicateCredentialKeyError{KeyID: keyID}
+		}
+		return nil
+	}
+	if k.keys == nil {
+		k.keys = make(map[string]aesGCMKey)
+	}
+	k.keys[keyID] = aesGCMKey{key: append([]byte(nil), key...), aead: aead}
+	return nil
+}
+
+// Activate seals every subsequent credential under the key named keyID.
+func (k *AESGCMKeyring) Activate(keyID string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if _, ok := k.keys[keyID]; !ok {
+		return UnknownCredentialKeyError{KeyID: keyID}
+	}
+	k.activeKeyID = keyID
+	return nil
+}
+
+// Rotate adds key and makes it the active key. Like AddKey, it refuses to
+// replace an existing key id with a different key.
+func (k *AESGCMKeyring) Rotate(keyID string, key []byte) error {
+	err := k.AddKey(keyID, key)
+	if err != nil {
+		return err
+	}
+	return k.Activate(keyID)
+}
+
+func (k *AESGCMKeyring) RemoveKey(keyID string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if keyID == k.activeKeyID {
+		return ErrActiveCredentialKey
+	}
+	delete(k.keys, keyID)
+	return nil
+}
+
+// NeedsReseal reports whether sealed was sealed under a key other than the
+// active one.
+func (k *AESGCMKeyring) NeedsReseal(sealed *SealedCredential) bool {
+	return sealed != nil && sealed.KeyID != k.ActiveKeyID()
+}
+
+// Seal encrypts plaintext under the active key. The key id is bound to the
+// ciphertext as additional data.
+func (k *AESGCMKeyring) Seal(plaintext []byte) (*SealedCredential, error) {
+	k.lock.RLock()
+	keyID := k.activeKeyID
+	aead := k.keys[keyID].aead
+	k.lock.RUnlock()
+	if aead == nil {
+		return nil, ErrNoActiveCredentialKey
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SealedCredential{
+		KeyID:      keyID,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, []byte(keyID)),
+	}, nil
+}
+
+func (k *AESGCMKeyring) Open(sealed *SealedCredential) ([]byte, error) {
+	if sealed == nil {
+		return nil, ErrNoSealedCredential
+	}
+
+	k.lock.RLock()
+	key, ok := k.keys[sealed.KeyID]
+	k.lock.RUnlock()
+	if !ok {
+		return nil, UnknownCredentialKeyError{KeyID: sealed.KeyID}
+	}
+	aead := key.aead
+
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, ErrCredentialDecryptionFailed
+	}
+	plaintext, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(sealed.KeyID))
+	if err != nil {
+		return nil, ErrCredentialDecryptionFailed
+	}
+	return plaintext, nil
+}
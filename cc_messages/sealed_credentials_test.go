@@ -0,0 +1,303 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SealedCredentials", func() {
+	var keyring *cc_messages.AESGCMKeyring
+
+	key := func(b byte) []byte {
+		k := make([]byte, 32)
+		for i := range k {
+			k[i] = b
+		}
+		return k
+	}
+
+	BeforeEach(func() {
+		var err error
+		keyring, err = cc_messages.NewAESGCMKeyring("key-1", map[string][]byte{"key-1": key(1)})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("AESGCMKeyring", func() {
+		It("opens what it sealed", func() {
+			sealed, err := keyring.Seal([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sealed.KeyID).To(Equal("key-1"))
+			Expect(sealed.Ciphertext).NotTo(ContainSubstring("secret"))
+
+			plaintext, err := keyring.Open(sealed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(plaintext)).To(Equal("secret"))
+		})
+
+		It("uses a fresh nonce for every seal", func() {
+			first, err := keyring.Seal([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+			second, err := keyring.Seal([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first.Nonce).NotTo(Equal(second.Nonce))
+		})
+
+		It("rejects tampered credentials", func() {
+			sealed, err := keyring.Seal([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+
+			sealed.Ciphertext[0] ^= 0xff
+			_, err = keyring.Open(sealed)
+			Expect(err).To(Equal(cc_messages.ErrCredentialDecryptionFailed))
+		})
+
+		It("binds the key id to the ciphertext", func() {
+			Expect(keyring.AddKey("key-2", key(1))).To(Succeed())
+			sealed, err := keyring.Seal([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+
+			sealed.KeyID = "key-2"
+			_, err = keyring.Open(sealed)
+			Expect(err).To(Equal(cc_messages.ErrCredentialDecryptionFailed))
+		})
+
+		It("rotates to a new key and keeps opening credentials sealed under the old one", func() {
+			old, err := keyring.Seal([]byte("old-secret"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(keyring.Rotate("key-2", key(2))).To(Succeed())
+			Expect(keyring.ActiveKeyID()).To(Equal("key-2"))
+			Expect(keyring.NeedsReseal(old)).To(BeTrue())
+
+			plaintext, err := keyring.Open(old)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(plaintext)).To(Equal("old-secret"))
+
+			resealed, err := keyring.Seal(plaintext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resealed.KeyID).To(Equal("key-2"))
+			Expect(keyring.NeedsReseal(resealed)).To(BeFalse())
+
+			Expect(keyring.RemoveKey("key-1")).To(Succeed())
+			_, err = keyring.Open(old)
+			Expect(err).To(Equal(cc_messages.UnknownCredentialKeyError{KeyID: "key-1"}))
+		})
+
+		It("refuses to replace a key with different key bytes", func() {
+			sealed, err := keyring.Seal([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(keyring.AddKey("key-1", key(2))).To(Equal(cc_messages.DuplicateCredentialKeyError{KeyID: "key-1"}))
+			Expect(keyring.Rotate("key-1", key(2))).To(Equal(cc_messages.DuplicateCredentialKeyError{KeyID: "key-1"}))
+			Expect(keyring.AddKey("key-1", key(1))).To(Succeed())
+
+			plaintext, err := keyring.Open(sealed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(plaintext)).To(Equal("secret"))
+		})
+
+		It("keeps the active key", func() {
+			Expect(keyring.RemoveKey("key-1")).To(Equal(cc_messages.ErrActiveCredentialKey))
+		})
+
+		It("errors on a nil sealed credential", func() {
+			_, err := keyring.Open(nil)
+			Expect(err).To(Equal(cc_messages.ErrNoSealedCredential))
+		})
+
+		It("errors when the keyring has no active key", func() {
+			var empty cc_messages.AESGCMKeyring
+			_, err := empty.Seal([]byte("secret"))
+			Expect(err).To(Equal(cc_messages.ErrNoActiveCredentialKey))
+
+			Expect(empty.AddKey("key-1", key(1))).To(Succeed())
+			_, err = empty.Seal([]byte("secret"))
+			Expect(err).To(Equal(cc_messages.ErrNoActiveCredentialKey))
+		})
+
+		It("rejects invalid keys", func() {
+			_, err := cc_messages.NewAESGCMKeyring("key-1", map[string][]byte{"key-1": []byte("short")})
+			Expect(err).To(MatchError(ContainSubstring(`invalid credential key "key-1"`)))
+
+			_, err = cc_messages.NewAESGCMKeyring("missing", map[string][]byte{"key-1": key(1)})
+			Expect(err).To(Equal(cc_messages.UnknownCredentialKeyError{KeyID: "missing"}))
+		})
+	})
+
+	Describe("encoded sealed credentials", func() {
+		It("round-trips through a single string", func() {
+			sealed, err := keyring.Seal([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+
+			encoded, err := sealed.Encode()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encoded).To(HavePrefix(cc_messages.EncodedSealedCredentialPrefix))
+			Expect(cc_messages.IsEncodedSealedCredential(encoded)).To(BeTrue())
+
+			decoded, err := cc_messages.DecodeSealedCredential(encoded)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(sealed))
+
+			plaintext, err := cc_messages.OpenCredential(keyring, encoded)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plaintext).To(Equal("secret"))
+		})
+
+		It("returns plaintext credentials as they are", func() {
+			plaintext, err := cc_messages.OpenCredential(nil, "secret")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plaintext).To(Equal("secret"))
+		})
+
+		It("requires a keyring to open an encoded credential", func() {
+			sealed, err := keyring.Seal([]byte("secret"))
+			Expect(err).NotTo(HaveOccurred())
+			encoded, err := sealed.Encode()
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cc_messages.OpenCredential(nil, encoded)
+			Expect(err).To(Equal(cc_messages.ErrNoCredentialKeyring))
+		})
+
+		It("rejects malformed encodings", func() {
+			_, err := cc_messages.DecodeSealedCredential(cc_messages.EncodedSealedCredentialPrefix + "!!!")
+			Expect(err).To(Equal(cc_messages.ErrMalformedSealedCredential))
+
+			_, err = cc_messages.DecodeSealedCredential("secret")
+			Expect(err).To(Equal(cc_messages.ErrMalformedSealedCredential))
+		})
+	})
+
+	Describe("sealing docker passwords", func() {
+		var desireAppRequest cc_messages.DesireAppRequestFromCC
+
+		BeforeEach(func() {
+			desireAppRequest = cc_messages.DesireAppRequestFromCC{
+				ProcessGuid:    "process-guid",
+				DockerImageUrl: "docker:///user/repo",
+				RegistryCredentials: cc_messages.RegistryCredentials{
+					DockerUser:     "user",
+					DockerPassword: "docker-secret",
+				},
+				MemoryMB: 128,
+				DiskMB:   512,
+			}
+		})
+
+		It("never stores the plaintext password", func() {
+			Expect(desireAppRequest.SealDockerPassword(keyring)).To(Succeed())
+			Expect(desireAppRequest.DockerPassword).To(BeEmpty())
+			Expect(desireAppRequest.Validate()).To(Succeed())
+
+			payload, err := json.Marshal(desireAppRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(payload)).NotTo(ContainSubstring("docker-secret"))
+			Expect(string(payload)).NotTo(ContainSubstring(`"docker_password"`))
+			Expect(string(payload)).To(ContainSubstring(`"sealed_docker_password":{"key_id":"key-1"`))
+
+			var decoded cc_messages.DesireAppRequestFromCC
+			Expect(json.Unmarshal(payload, &decoded)).To(Succeed())
+			Expect(decoded.UnsealDockerPassword(keyring)).To(Succeed())
+			Expect(decoded.DockerPassword).To(Equal("docker-secret"))
+			Expect(decoded.SealedDockerPassword).To(BeNil())
+		})
+
+		It("accepts plaintext messages", func() {
+			Expect(desireAppRequest.UnsealDockerPassword(nil)).To(Succeed())
+			Expect(desireAppRequest.DockerPassword).To(Equal("docker-secret"))
+
+			password, err := desireAppRequest.OpenDockerPassword(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password).To(Equal("docker-secret"))
+		})
+
+		It("does not seal an empty password", func() {
+			desireAppRequest.DockerPassword = ""
+			Expect(desireAppRequest.SealDockerPassword(keyring)).To(Succeed())
+			Expect(desireAppRequest.SealedDockerPassword).To(BeNil())
+		})
+
+		It("requires a keyring to open a sealed password", func() {
+			Expect(desireAppRequest.SealDockerPassword(keyring)).To(Succeed())
+			Expect(desireAppRequest.UnsealDockerPassword(nil)).To(Equal(cc_messages.ErrNoCredentialKeyring))
+		})
+
+		It("keeps the fingerprint stable across seals", func() {
+			plaintext, err := desireAppRequest.Fingerprint()
+			Expect(err).NotTo(HaveOccurred())
+
+			sealed := desireAppRequest
+			Expect(sealed.SealDockerPassword(keyring)).To(Succeed())
+			Expect(keyring.Rotate("key-2", key(2))).To(Succeed())
+			resealed := desireAppRequest
+			Expect(resealed.SealDockerPassword(keyring)).To(Succeed())
+
+			first, err := sealed.FingerprintWithKeyring(keyring)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := resealed.FingerprintWithKeyring(keyring)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first).To(Equal(second))
+			Expect(first).To(Equal(plaintext))
+		})
+
+		It("changes the fingerprint when only the password changes", func() {
+			before, err := desireAppRequest.Fingerprint()
+			Expect(err).NotTo(HaveOccurred())
+
+			desireAppRequest.DockerPassword = "rotated-secret"
+			after, err := desireAppRequest.Fingerprint()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(after).NotTo(Equal(before))
+
+			Expect(desireAppRequest.SealDockerPassword(keyring)).To(Succeed())
+			sealed, err := desireAppRequest.FingerprintWithKeyring(keyring)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sealed).To(Equal(after))
+		})
+
+		It("requires a keyring to fingerprint a sealed password", func() {
+			Expect(desireAppRequest.SealDockerPassword(keyring)).To(Succeed())
+
+			_, err := desireAppRequest.Fingerprint()
+			Expect(err).To(Equal(cc_messages.ErrNoCredentialKeyring))
+		})
+
+		It("rejects messages carrying both forms", func() {
+			sealed, err := keyring.Seal([]byte("docker-secret"))
+			Expect(err).NotTo(HaveOccurred())
+			desireAppRequest.SealedDockerPassword = sealed
+
+			Expect(desireAppRequest.Validate()).To(MatchError(ContainSubstring("docker_password")))
+		})
+
+		It("seals task requests and docker staging data", func() {
+			task := cc_messages.TaskRequestFromCC{
+				TaskGuid:   "task-guid",
+				Lifecycle:  cc_messages.DOCKER_LIFECYCLE,
+				DockerPath: "docker:///user/repo",
+				RegistryCredentials: cc_messages.RegistryCredentials{
+					DockerUser:     "user",
+					DockerPassword: "docker-secret",
+				},
+			}
+			Expect(task.SealDockerPassword(keyring)).To(Succeed())
+			Expect(task.DockerPassword).To(BeEmpty())
+			Expect(task.Validate()).To(Succeed())
+			Expect(task.UnsealDockerPassword(keyring)).To(Succeed())
+			Expect(task.DockerPassword).To(Equal("docker-secret"))
+
+			stagingData := cc_messages.DockerStagingData{
+				DockerImageUrl:      "user/repo",
+				RegistryCredentials: cc_messages.RegistryCredentials{DockerPassword: "docker-secret"},
+			}
+			Expect(stagingData.SealDockerPassword(keyring)).To(Succeed())
+			Expect(stagingData.DockerPassword).To(BeEmpty())
+			Expect(stagingData.UnsealDockerPassword(keyring)).To(Succeed())
+			Expect(stagingData.DockerPassword).To(Equal("docker-secret"))
+		})
+	})
+})
@@ -0,0 +1,111 @@
+package cc_messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrLifecycleDataMissing = errors.New("Invalid lifecycle data: missing")
+
+type UnknownLifecycleError struct {
+	Lifecycle string
+}
+
+func (e UnknownLifecycleError) Error() string {
+	return fmt.Sprintf("Invalid lifecycle: unknown lifecycle %q", e.Lifecycle)
+}
+
+type MalformedLifecycleDataError struct {
+	Lifecycle string
+	Err       error
+}
+
+func (e MalformedLifecycleDataError) Error() string {
+	return fmt.Sprintf("Invalid lifecycle data: malformed %s data: %s", e.Lifecycle, e.Err)
+}
+
+// LifecycleData is the typed form of StagingRequestFromCC.LifecycleData.
+type LifecycleData interface {
+	LifecycleName() string
+}
+
+func (BuildpackStagingData) LifecycleName() string { return BUILDPACK_LIFECYCLE }
+func (DockerStagingData) LifecycleName() string    { return DOCKER_LIFECYCLE }
+func (CNBStagingData) LifecycleName() string       { return CNB_LIFECYCLE }
+
+// DecodeLifecycleData unmarshals LifecycleData into the staging data type
+// registered for Lifecycle in the DefaultLifecycleRegistry.
+func (r StagingRequestFromCC) DecodeLifecycleData() (LifecycleData, error) {
+	return DefaultLifecycleRegistry.DecodeStagingData(r)
+}
+
+func (reg *LifecycleRegistry) DecodeStagingData(r StagingRequestFromCC) (LifecycleData, error) {
+	lifecycle, found := reg.Lookup(r.Lifecycle)
+	if !found {
+		return nil, UnknownLifecycleError{Lifecycle: r.Lifecycle}
+	}
+
+	if r.LifecycleData == nil || string(*r.LifecycleData) == "null" {
+		return nil, ErrLifecycleDataMissing
+	}
+
+	data := newZeroValue(lifecycle.StagingData)
+	err := json.Unmarshal(*r.LifecycleData, data)
+	if err != nil {
+		return nil, MalformedLifecycleDataError{Lifecycle: r.Lifecycle, Err: err}
+	}
+
+	return dereference(data).(LifecycleData), nil
+}
+
+// Validate checks that Lifecycle is registered and that LifecycleData
+// decodes and passes the lifecycle's validation.
+func (r StagingRequestFromCC) Validate() error {
+	return DefaultLifecycleRegistry.ValidateStagingRequest(r)
+}
+
+func (reg *LifecycleRegistry) ValidateStagingRequest(r StagingRequestFromCC) error {
+	var validationError ValidationError
+
+	if r.AppId == "" {
+		validationError = validationError.Append("app_id", "cannot be empty")
+	}
+
+	lifecycle, found := reg.Lookup(r.Lifecycle)
+	if !found {
+		validationError = validationError.Append("lifecycle", "must be one of "+reg.namesList())
+		return validationError.ToError()
+	}
+
+	data, err := reg.DecodeStagingData(r)
+	switch err.(type) {
+	case nil:
+		if lifecycle.ValidateStagingData != nil {
+			validationError = validationError.Nest("lifecycle_data", lifecycle.ValidateStagingData(data))
+		}
+	case MalformedLifecycleDataError:
+		validationError = validationError.Append("lifecycle_data", "is malformed")
+	default:
+		validationError = validationError.Append("lifecycle_data", "cannot be empty")
+	}
+
+	return validationError.ToError()
+}
+
+// EncodeLifecycleData sets Lifecycle and LifecycleData from typed data.
+func (r *StagingRequestFromCC) EncodeLifecycleData(data LifecycleData) error {
+	if data == nil {
+		return ErrLifecycleDataMissing
+	}
+
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	lifecycleData := json.RawMessage(dataJson)
+	r.Lifecycle = data.LifecycleName()
+	r.LifecycleData = &lifecycleData
+	return nil
+}
@@ -0,0 +1,140 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingLifecycleData", func() {
+	var stagingRequest cc_messages.StagingRequestFromCC
+
+	rawMessage := func(payload string) *json.RawMessage {
+		message := json.RawMessage(payload)
+		return &message
+	}
+
+	Describe("DecodeLifecycleData", func() {
+		Context("with the buildpack lifecycle", func() {
+			BeforeEach(func() {
+				stagingRequest = cc_messages.StagingRequestFromCC{
+					Lifecycle: "buildpack",
+					LifecycleData: rawMessage(`{
+						"app_bits_download_uri": "http://fake-download_uri",
+						"buildpacks": [{"name": "ruby", "key": "ruby-key", "url": "ruby-url"}],
+						"stack": "pancakes"
+					}`),
+				}
+			})
+
+			It("returns BuildpackStagingData", func() {
+				data, err := stagingRequest.DecodeLifecycleData()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(data).To(Equal(cc_messages.BuildpackStagingData{
+					AppBitsDownloadUri: "http://fake-download_uri",
+					Buildpacks: []cc_messages.Buildpack{
+						{Name: "ruby", Key: "ruby-key", Url: "ruby-url"},
+					},
+					Stack: "pancakes",
+				}))
+			})
+		})
+
+		Context("with the docker lifecycle", func() {
+			BeforeEach(func() {
+				stagingRequest = cc_messages.StagingRequestFromCC{
+					Lifecycle:     "docker",
+					LifecycleData: rawMessage(`{"docker_image": "docker:///diego/image"}`),
+				}
+			})
+
+			It("returns DockerStagingData", func() {
+				data, err := stagingRequest.DecodeLifecycleData()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(data).To(Equal(cc_messages.DockerStagingData{
+					DockerImageUrl: "docker:///diego/image",
+				}))
+			})
+		})
+
+		Context("with an unknown lifecycle", func() {
+			BeforeEach(func() {
+				stagingRequest = cc_messages.StagingRequestFromCC{
+					Lifecycle:     "windows",
+					LifecycleData: rawMessage(`{}`),
+				}
+			})
+
+			It("returns an UnknownLifecycleError", func() {
+				_, err := stagingRequest.DecodeLifecycleData()
+				Expect(err).To(Equal(cc_messages.UnknownLifecycleError{Lifecycle: "windows"}))
+			})
+		})
+
+		Context("with missing lifecycle data", func() {
+			It("returns ErrLifecycleDataMissing", func() {
+				stagingRequest = cc_messages.StagingRequestFromCC{Lifecycle: "buildpack"}
+				_, err := stagingRequest.DecodeLifecycleData()
+				Expect(err).To(Equal(cc_messages.ErrLifecycleDataMissing))
+
+				stagingRequest.LifecycleData = rawMessage(`null`)
+				_, err = stagingRequest.DecodeLifecycleData()
+				Expect(err).To(Equal(cc_messages.ErrLifecycleDataMissing))
+			})
+		})
+
+		Context("with malformed lifecycle data", func() {
+			BeforeEach(func() {
+				stagingRequest = cc_messages.StagingRequestFromCC{
+					Lifecycle:     "docker",
+					LifecycleData: rawMessage(`{"docker_image": 42}`),
+				}
+			})
+
+			It("returns a MalformedLifecycleDataError", func() {
+				_, err := stagingRequest.DecodeLifecycleData()
+				Expect(err).To(BeAssignableToTypeOf(cc_messages.MalformedLifecycleDataError{}))
+				Expect(err.(cc_messages.MalformedLifecycleDataError).Lifecycle).To(Equal("docker"))
+			})
+		})
+	})
+
+	Describe("EncodeLifecycleData", func() {
+		BeforeEach(func() {
+			stagingRequest = cc_messages.StagingRequestFromCC{AppId: "fake-app_id"}
+		})
+
+		It("sets the lifecycle and its data", func() {
+			err := stagingRequest.EncodeLifecycleData(cc_messages.DockerStagingData{
+				DockerImageUrl: "docker:///diego/image",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(stagingRequest.Lifecycle).To(Equal("docker"))
+			Expect(string(*stagingRequest.LifecycleData)).To(MatchJSON(`{"docker_image": "docker:///diego/image"}`))
+		})
+
+		It("round-trips through DecodeLifecycleData", func() {
+			buildpackData := cc_messages.BuildpackStagingData{
+				AppBitsDownloadUri: "http://fake-download_uri",
+				DropletUploadUri:   "http://droplet-upload-uri",
+				Stack:              "pancakes",
+			}
+
+			err := stagingRequest.EncodeLifecycleData(buildpackData)
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := stagingRequest.DecodeLifecycleData()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal(buildpackData))
+		})
+
+		It("errors without data", func() {
+			err := stagingRequest.EncodeLifecycleData(nil)
+			Expect(err).To(Equal(cc_messages.ErrLifecycleDataMissing))
+		})
+	})
+})
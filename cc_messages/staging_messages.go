@@ -48,11 +48,92 @@ type BuildpackStagingData struct {
 }
 
 type DockerStagingData struct {
-	DockerImageUrl    string `json:"docker_image"`
-	DockerLoginServer string `json:"docker_login_server,omitempty"`
-	DockerUser        string `json:"docker_user,omitempty"`
-	DockerPassword    string `json:"docker_password,omitempty"`
-	DockerEmail       string `json:"docker_email,omitempty"`
+	DockerImageUrl string `json:"docker_image"`
+	RegistryCredentials
+}
+
+type CNBStagingData struct {
+	AppBitsDownloadUri             string                   `json:"app_bits_download_uri"`
+	BuildArtifactsCacheDownloadUri string                   `json:"build_artifacts_cache_download_uri,omitempty"`
+	BuildArtifactsCacheUploadUri   string                   `json:"build_artifacts_cache_upload_uri"`
+	Buildpacks                     []Buildpack              `json:"buildpacks"`
+	DropletUploadUri               string                   `json:"droplet_upload_uri"`
+	Stack                          string                   `json:"stack"`
+	RunImage                       string                   `json:"run_image,omitempty"`
+	Credentials                    map[string]CNBCredential `json:"credentials,omitempty"`
+}
+
+// CNBCredential authenticates against a private buildpack registry. The
+// registry host is the key in CNBStagingData.Credentials.
+type CNBCredential struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+}
+
+// BuildpackStagingResult is the result the buildpack lifecycle's builder
+// reports for a successful staging.
+type BuildpackStagingResult struct {
+	LifecycleType     string                     `json:"lifecycle_type"`
+	ProcessTypes      map[string]string          `json:"process_types"`
+	ExecutionMetadata string                     `json:"execution_metadata"`
+	LifecycleMetadata BuildpackLifecycleMetadata `json:"lifecycle_metadata"`
+}
+
+type BuildpackLifecycleMetadata struct {
+	BuildpackKey      string              `json:"buildpack_key,omitempty"`
+	DetectedBuildpack string              `json:"detected_buildpack"`
+	Buildpacks        []BuildpackMetadata `json:"buildpacks"`
+}
+
+type BuildpackMetadata struct {
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// DockerStagingResult is the result the docker lifecycle's builder reports
+// for a successful staging. ExecutionMetadata holds the image's command,
+// entrypoint, user and exposed ports as JSON.
+type DockerStagingResult struct {
+	LifecycleType     string                  `json:"lifecycle_type"`
+	ProcessTypes      map[string]string       `json:"process_types"`
+	ExecutionMetadata string                  `json:"execution_metadata"`
+	LifecycleMetadata DockerLifecycleMetadata `json:"lifecycle_metadata"`
+}
+
+type DockerLifecycleMetadata struct {
+	DockerImage string `json:"docker_image"`
+}
+
+type CNBStagingResult struct {
+	LifecycleType     string               `json:"lifecycle_type"`
+	ProcessTypes      map[string]string    `json:"process_types"`
+	ExecutionMetadata string               `json:"execution_metadata"`
+	LifecycleMetadata CNBLifecycleMetadata `json:"lifecycle_metadata"`
+}
+
+type CNBLifecycleMetadata struct {
+	Stack          string                 `json:"stack"`
+	RunImage       string                 `json:"run_image,omitempty"`
+	Buildpacks     []CNBBuildpackMetadata `json:"buildpacks"`
+	LaunchMetadata CNBLaunchMetadata      `json:"launch_metadata"`
+}
+
+type CNBBuildpackMetadata struct {
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type CNBLaunchMetadata struct {
+	Processes []CNBProcess `json:"processes"`
+}
+
+type CNBProcess struct {
+	Type    string   `json:"type"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Direct  bool     `json:"direct"`
 }
 
 const CUSTOM_BUILDPACK = "custom"
@@ -69,6 +150,12 @@ type StagingResponseForCC struct {
 	Result *json.RawMessage `json:"result,omitempty"`
 }
 
+// DecodeResult unmarshals Result into the staging result type registered for
+// the given lifecycle in the DefaultLifecycleRegistry.
+func (r StagingResponseForCC) DecodeResult(lifecycle string) (interface{}, error) {
+	return DefaultLifecycleRegistry.DecodeStagingResult(lifecycle, r)
+}
+
 type StagingTaskAnnotation struct {
 	Lifecycle          string `json:"lifecycle"`
 	CompletionCallback string `json:"completion_callback"`
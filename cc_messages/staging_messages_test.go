@@ -97,6 +97,147 @@ var _ = Describe("StagingMessages", func() {
 		})
 	})
 
+	Describe("CNBStagingData", func() {
+		lifecycleDataJSON := `{
+				"app_bits_download_uri" : "http://fake-download_uri",
+				"build_artifacts_cache_download_uri" : "http://cache-download-uri",
+				"build_artifacts_cache_upload_uri" : "http://cache-upload-uri",
+				"buildpacks" : [{"name":"node", "key":"node-key", "url":"docker://registry.example.com/node-cnb:1.0"}],
+				"droplet_upload_uri" : "http://droplet-upload-uri",
+				"stack": "cflinuxfs4",
+				"run_image": "registry.example.com/run:cflinuxfs4",
+				"credentials": {"registry.example.com": {"username": "user", "password": "secret"}}
+			}`
+
+		It("unmarshals correctly", func() {
+			var lifecycleData cc_messages.CNBStagingData
+			err := json.Unmarshal([]byte(lifecycleDataJSON), &lifecycleData)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(lifecycleData).To(Equal(cc_messages.CNBStagingData{
+				AppBitsDownloadUri:             "http://fake-download_uri",
+				BuildArtifactsCacheDownloadUri: "http://cache-download-uri",
+				BuildArtifactsCacheUploadUri:   "http://cache-upload-uri",
+				Buildpacks: []cc_messages.Buildpack{
+					{Name: "node", Key: "node-key", Url: "docker://registry.example.com/node-cnb:1.0"},
+				},
+				DropletUploadUri: "http://droplet-upload-uri",
+				Stack:            "cflinuxfs4",
+				RunImage:         "registry.example.com/run:cflinuxfs4",
+				Credentials: map[string]cc_messages.CNBCredential{
+					"registry.example.com": {Username: "user", Password: "secret"},
+				},
+			}))
+		})
+
+		It("is decoded and validated for the cnb lifecycle", func() {
+			data := json.RawMessage(`{"buildpacks": [{"name": "node"}], "credentials": {"registry.example.com": {}}}`)
+			stagingRequest := cc_messages.StagingRequestFromCC{
+				AppId:         "fake-app_id",
+				Lifecycle:     "cnb",
+				LifecycleData: &data,
+			}
+
+			decoded, err := stagingRequest.DecodeLifecycleData()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(BeAssignableToTypeOf(cc_messages.CNBStagingData{}))
+
+			err = stagingRequest.Validate()
+			Expect(err).To(BeAssignableToTypeOf(cc_messages.ValidationError{}))
+			Expect(err.(cc_messages.ValidationError).Paths()).To(Equal([]string{
+				"lifecycle_data.app_bits_download_uri",
+				"lifecycle_data.droplet_upload_uri",
+				"lifecycle_data.buildpacks[0].url",
+				`lifecycle_data.credentials["registry.example.com"].username`,
+			}))
+		})
+	})
+
+	Describe("BuildpackStagingResult", func() {
+		It("is decoded from the staging response for the buildpack lifecycle", func() {
+			result := json.RawMessage(`{
+				"lifecycle_type": "buildpack",
+				"process_types": {"web": "bundle exec rackup"},
+				"execution_metadata": "{\"start_command\":\"bundle exec rackup\"}",
+				"lifecycle_metadata": {
+					"buildpack_key": "ruby-key",
+					"detected_buildpack": "ruby 1.8.4",
+					"buildpacks": [{"key": "ruby-key", "name": "ruby_buildpack", "version": "1.8.4"}]
+				}
+			}`)
+
+			decoded, err := cc_messages.StagingResponseForCC{Result: &result}.DecodeResult("buildpack")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(cc_messages.BuildpackStagingResult{
+				LifecycleType:     "buildpack",
+				ProcessTypes:      map[string]string{"web": "bundle exec rackup"},
+				ExecutionMetadata: `{"start_command":"bundle exec rackup"}`,
+				LifecycleMetadata: cc_messages.BuildpackLifecycleMetadata{
+					BuildpackKey:      "ruby-key",
+					DetectedBuildpack: "ruby 1.8.4",
+					Buildpacks: []cc_messages.BuildpackMetadata{
+						{Key: "ruby-key", Name: "ruby_buildpack", Version: "1.8.4"},
+					},
+				},
+			}))
+		})
+	})
+
+	Describe("DockerStagingResult", func() {
+		It("is decoded from the staging response for the docker lifecycle", func() {
+			result := json.RawMessage(`{
+				"lifecycle_type": "docker",
+				"process_types": {"web": "/start"},
+				"execution_metadata": "{\"cmd\":[\"/start\"]}",
+				"lifecycle_metadata": {"docker_image": "cloudfoundry/diego-docker-app:latest"}
+			}`)
+
+			decoded, err := cc_messages.StagingResponseForCC{Result: &result}.DecodeResult("docker")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(cc_messages.DockerStagingResult{
+				LifecycleType:     "docker",
+				ProcessTypes:      map[string]string{"web": "/start"},
+				ExecutionMetadata: `{"cmd":["/start"]}`,
+				LifecycleMetadata: cc_messages.DockerLifecycleMetadata{DockerImage: "cloudfoundry/diego-docker-app:latest"},
+			}))
+		})
+	})
+
+	Describe("CNBStagingResult", func() {
+		It("is decoded from the staging response for the cnb lifecycle", func() {
+			result := json.RawMessage(`{
+				"lifecycle_type": "cnb",
+				"process_types": {"web": "npm start"},
+				"execution_metadata": "",
+				"lifecycle_metadata": {
+					"stack": "cflinuxfs4",
+					"buildpacks": [{"key": "node-key", "name": "paketo-buildpacks/nodejs", "version": "1.2.3"}],
+					"launch_metadata": {
+						"processes": [{"type": "web", "command": "npm", "args": ["start"], "direct": true}]
+					}
+				}
+			}`)
+
+			decoded, err := cc_messages.StagingResponseForCC{Result: &result}.DecodeResult("cnb")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(cc_messages.CNBStagingResult{
+				LifecycleType: "cnb",
+				ProcessTypes:  map[string]string{"web": "npm start"},
+				LifecycleMetadata: cc_messages.CNBLifecycleMetadata{
+					Stack: "cflinuxfs4",
+					Buildpacks: []cc_messages.CNBBuildpackMetadata{
+						{Key: "node-key", Name: "paketo-buildpacks/nodejs", Version: "1.2.3"},
+					},
+					LaunchMetadata: cc_messages.CNBLaunchMetadata{
+						Processes: []cc_messages.CNBProcess{
+							{Type: "web", Command: "npm", Args: []string{"start"}, Direct: true},
+						},
+					},
+				},
+			}))
+		})
+	})
+
 	Describe("Buildpack", func() {
 		Context("when skipping the detect phase is not specified", func() {
 			ccJSONFragment := `{
@@ -0,0 +1,97 @@
+package cc_messages
+
+import (
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// TaskState is the state of a task as the CC sees it.
+type TaskState string
+
+const (
+	TaskStatePending   TaskState = "PENDING"
+	TaskStateRunning   TaskState = "RUNNING"
+	TaskStateCanceling TaskState = "CANCELING"
+	TaskStateSucceeded TaskState = "SUCCEEDED"
+	TaskStateFailed    TaskState = "FAILED"
+)
+
+var ErrInvalidBBSTaskState = errors.New("invalid bbs task state")
+
+type InvalidTaskStateTransitionError struct {
+	From TaskState
+	To   TaskState
+}
+
+func (e InvalidTaskStateTransitionError) Error() string {
+	return fmt.Sprintf("invalid task state transition from %q to %q", e.From, e.To)
+}
+
+// taskStateTransitions lists the states each state may move to. A task can
+// fail or be cancelled at any point until it finished; a cancelled task may
+// still succeed if it completed before the cancel took effect.
+var taskStateTransitions = map[TaskState][]TaskState{
+	TaskStatePending:   {TaskStateRunning, TaskStateCanceling, TaskStateSucceeded, TaskStateFailed},
+	TaskStateRunning:   {TaskStateCanceling, TaskStateSucceeded, TaskStateFailed},
+	TaskStateCanceling: {TaskStateSucceeded, TaskStateFailed},
+	TaskStateSucceeded: {},
+	TaskStateFailed:    {},
+}
+
+func (s TaskState) Valid() bool {
+	_, ok := taskStateTransitions[s]
+	return ok
+}
+
+// Terminal reports whether the task finished, successfully or not.
+func (s TaskState) Terminal() bool {
+	transitions, ok := taskStateTransitions[s]
+	return ok && len(transitions) == 0
+}
+
+// CanTransitionTo reports whether a task may move from s to next. Staying in
+// the same state is always allowed.
+func (s TaskState) CanTransitionTo(next TaskState) bool {
+	if s == next {
+		return s.Valid()
+	}
+
+	for _, state := range taskStateTransitions[s] {
+		if state == next {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateTaskStateTransition(from, to TaskState) error {
+	if !from.CanTransitionTo(to) {
+		return InvalidTaskStateTransitionError{From: from, To: to}
+	}
+	return nil
+}
+
+// TaskStateFromBBS computes the CC-facing state of a bbs task. Completed
+// and resolving tasks succeeded unless failed is set; bbs records
+// cancellation as a failure.
+func TaskStateFromBBS(state models.Task_State, failed bool) (TaskState, error) {
+	switch state {
+	case models.Task_Pending:
+		return TaskStatePending, nil
+	case models.Task_Running:
+		return TaskStateRunning, nil
+	case models.Task_Completed, models.Task_Resolving:
+		if failed {
+			return TaskStateFailed, nil
+		}
+		return TaskStateSucceeded, nil
+	default:
+		return "", ErrInvalidBBSTaskState
+	}
+}
+
+func TaskStateFromBBSTask(task *models.Task) (TaskState, error) {
+	return TaskStateFromBBS(task.State, task.Failed)
+}
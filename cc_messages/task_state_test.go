@@ -0,0 +1,101 @@
+package cc_messages_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TaskState", func() {
+	allStates := []cc_messages.TaskState{
+		cc_messages.TaskStatePending,
+		cc_messages.TaskStateRunning,
+		cc_messages.TaskStateCanceling,
+		cc_messages.TaskStateSucceeded,
+		cc_messages.TaskStateFailed,
+	}
+
+	It("knows every state", func() {
+		for _, state := range allStates {
+			Expect(state.Valid()).To(BeTrue(), string(state))
+		}
+		Expect(cc_messages.TaskState("BOGUS").Valid()).To(BeFalse())
+	})
+
+	It("treats succeeded and failed as terminal", func() {
+		var terminal []cc_messages.TaskState
+		for _, state := range allStates {
+			if state.Terminal() {
+				terminal = append(terminal, state)
+			}
+		}
+		Expect(terminal).To(ConsistOf(cc_messages.TaskStateSucceeded, cc_messages.TaskStateFailed))
+		Expect(cc_messages.TaskState("BOGUS").Terminal()).To(BeFalse())
+	})
+
+	It("never leaves a terminal state", func() {
+		for _, from := range []cc_messages.TaskState{cc_messages.TaskStateSucceeded, cc_messages.TaskStateFailed} {
+			for _, to := range allStates {
+				if to != from {
+					Expect(from.CanTransitionTo(to)).To(BeFalse(), string(from)+" -> "+string(to))
+				}
+			}
+		}
+	})
+
+	It("keeps its JSON encoding", func() {
+		payload, err := json.Marshal(cc_messages.CCTaskState{TaskGuid: "task-guid", State: cc_messages.TaskStateFailed})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payload).To(MatchJSON(`{"task_guid":"task-guid","state":"FAILED","completion_callback":""}`))
+	})
+
+	DescribeTable("ValidateTaskStateTransition",
+		func(from, to cc_messages.TaskState, valid bool) {
+			err := cc_messages.ValidateTaskStateTransition(from, to)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(Equal(cc_messages.InvalidTaskStateTransitionError{From: from, To: to}))
+			}
+		},
+		Entry("pending to running", cc_messages.TaskStatePending, cc_messages.TaskStateRunning, true),
+		Entry("pending to failed", cc_messages.TaskStatePending, cc_messages.TaskStateFailed, true),
+		Entry("running to canceling", cc_messages.TaskStateRunning, cc_messages.TaskStateCanceling, true),
+		Entry("running to succeeded", cc_messages.TaskStateRunning, cc_messages.TaskStateSucceeded, true),
+		Entry("canceling to failed", cc_messages.TaskStateCanceling, cc_messages.TaskStateFailed, true),
+		Entry("running to running", cc_messages.TaskStateRunning, cc_messages.TaskStateRunning, true),
+		Entry("running to pending", cc_messages.TaskStateRunning, cc_messages.TaskStatePending, false),
+		Entry("canceling to running", cc_messages.TaskStateCanceling, cc_messages.TaskStateRunning, false),
+		Entry("succeeded to failed", cc_messages.TaskStateSucceeded, cc_messages.TaskStateFailed, false),
+		Entry("failed to running", cc_messages.TaskStateFailed, cc_messages.TaskStateRunning, false),
+		Entry("an unknown state", cc_messages.TaskState("BOGUS"), cc_messages.TaskState("BOGUS"), false),
+	)
+
+	DescribeTable("TaskStateFromBBS",
+		func(state models.Task_State, failed bool, expected cc_messages.TaskState) {
+			taskState, err := cc_messages.TaskStateFromBBS(state, failed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskState).To(Equal(expected))
+
+			taskState, err = cc_messages.TaskStateFromBBSTask(&models.Task{State: state, Failed: failed})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskState).To(Equal(expected))
+		},
+		Entry("pending", models.Task_Pending, false, cc_messages.TaskStatePending),
+		Entry("running", models.Task_Running, false, cc_messages.TaskStateRunning),
+		Entry("completed", models.Task_Completed, false, cc_messages.TaskStateSucceeded),
+		Entry("completed and failed", models.Task_Completed, true, cc_messages.TaskStateFailed),
+		Entry("resolving", models.Task_Resolving, false, cc_messages.TaskStateSucceeded),
+		Entry("resolving and failed", models.Task_Resolving, true, cc_messages.TaskStateFailed),
+	)
+
+	It("rejects invalid bbs states", func() {
+		_, err := cc_messages.TaskStateFromBBS(models.Task_Invalid, false)
+		Expect(err).To(Equal(cc_messages.ErrInvalidBBSTaskState))
+	})
+})
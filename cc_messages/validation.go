@@ -0,0 +1,63 @@
+package cc_messages
+
+import "bytes"
+
+// FieldError describes a single invalid field, identified by its JSON path
+// within the message (e.g. "routing_info.http_routes").
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError collects every FieldError found while validating a message.
+type ValidationError []FieldError
+
+func (ve ValidationError) Append(path, message string) ValidationError {
+	return append(ve, FieldError{Path: path, Message: message})
+}
+
+// Nest appends errs with each path prefixed by the given parent path.
+func (ve ValidationError) Nest(parent string, errs ValidationError) ValidationError {
+	for _, err := range errs {
+		ve = ve.Append(parent+"."+err.Path, err.Message)
+	}
+	return ve
+}
+
+func (ve ValidationError) ToError() error {
+	if len(ve) == 0 {
+		return nil
+	}
+	return ve
+}
+
+func (ve ValidationError) Error() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("Invalid fields: ")
+	for i, err := range ve {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(err.Error())
+	}
+
+	return buffer.String()
+}
+
+func (ve ValidationError) Empty() bool {
+	return len(ve) == 0
+}
+
+// Paths returns the JSON path of each invalid field, in the order found.
+func (ve ValidationError) Paths() []string {
+	paths := make([]string, 0, len(ve))
+	for _, err := range ve {
+		paths = append(paths, err.Path)
+	}
+	return paths
+}
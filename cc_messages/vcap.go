@@ -0,0 +1,284 @@
+package cc_messages
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+const (
+	VcapApplicationEnvironmentVariable = "VCAP_APPLICATION"
+	VcapServicesEnvironmentVariable    = "VCAP_SERVICES"
+
+	// vcapApplicationHost is the host the launcher tells apps to listen on.
+	vcapApplicationHost = "0.0.0.0"
+)
+
+// VcapApplication is the VCAP_APPLICATION variable. The CC sends the
+// application fields; Host, InstanceID, InstanceIndex and Port are added
+// per instance by the launcher. Fields not listed here are dropped when
+// parsing.
+type VcapApplication struct {
+	ApplicationID      string                 `json:"application_id"`
+	ApplicationName    string                 `json:"application_name"`
+	ApplicationURIs    []string               `json:"application_uris"`
+	ApplicationVersion string                 `json:"application_version"`
+	CFAPI              string                 `json:"cf_api,omitempty"`
+	Limits             *VcapApplicationLimits `json:"limits,omitempty"`
+	Name               string                 `json:"name"`
+	OrganizationID     string                 `json:"organization_id,omitempty"`
+	OrganizationName   string                 `json:"organization_name,omitempty"`
+	ProcessID          string                 `json:"process_id,omitempty"`
+	ProcessType        string                 `json:"process_type,omitempty"`
+	SpaceID            string                 `json:"space_id"`
+	SpaceName          string                 `json:"space_name"`
+	URIs               []string               `json:"uris"`
+	Users              []string               `json:"users"`
+	Version            string                 `json:"version"`
+
+	Host          string `json:"host,omitempty"`
+	InstanceID    string `json:"instance_id,omitempty"`
+	InstanceIndex *uint  `json:"instance_index,omitempty"`
+	Port          uint32 `json:"port,omitempty"`
+}
+
+// VcapApplicationLimits are in megabytes, except FDs.
+type VcapApplicationLimits struct {
+	Disk int    `json:"disk"`
+	FDs  uint64 `json:"fds"`
+	Mem  int    `json:"mem"`
+}
+
+// VcapServices is the VCAP_SERVICES variable: the bound service instances,
+// keyed by the label of their service offering.
+type VcapServices map[string][]VcapService
+
+type VcapService struct {
+	Name           string                   `json:"name"`
+	InstanceName   string                   `json:"instance_name,omitempty"`
+	BindingName    string                   `json:"binding_name,omitempty"`
+	Label          string                   `json:"label"`
+	Tags           []string                 `json:"tags"`
+	Plan           string                   `json:"plan"`
+	Provider       string                   `json:"provider,omitempty"`
+	Credentials    map[string]interface{}   `json:"credentials"`
+	SyslogDrainURL string                   `json:"syslog_drain_url,omitempty"`
+	VolumeMounts   []VcapServiceVolumeMount `json:"volume_mounts,omitempty"`
+}
+
+type VcapServiceVolumeMount struct {
+	ContainerDir string `json:"container_dir"`
+	DeviceType   string `json:"device_type"`
+	Mode         string `json:"mode"`
+}
+
+// Named returns the bound service instance called name. Labels are searched
+// in sorted order, so the result does not depend on map iteration.
+func (s VcapServices) Named(name string) (VcapService, bool) {
+	labels := make([]string, 0, len(s))
+	for label := range s {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		for _, service := range s[label] {
+			if service.Name == name {
+				return service, true
+			}
+		}
+	}
+	return VcapService{}, false
+}
+
+// ParseVcapApplication parses the VCAP_APPLICATION variable of env. It
+// returns nil when env does not set it.
+func ParseVcapApplication(env []*models.EnvironmentVariable) (*VcapApplication, error) {
+	value, found := lookupEnvironmentVariable(env, VcapApplicationEnvironmentVariable)
+	if !found {
+		return nil, nil
+	}
+
+	app := &VcapApplication{}
+	err := json.Unmarshal([]byte(value), app)
+	if err != nil {
+		return nil, InvalidEnvironmentVariableError{Name: VcapApplicationEnvironmentVariable, Reason: err.Error()}
+	}
+	return app, nil
+}
+
+// ParseVcapServices parses the VCAP_SERVICES variable of env. It returns
+// nil when env does not set it.
+func ParseVcapServices(env []*models.EnvironmentVariable) (VcapServices, error) {
+	value, found := lookupEnvironmentVariable(env, VcapServicesEnvironmentVariable)
+	if !found {
+		return nil, nil
+	}
+
+	var services VcapServices
+	err := json.Unmarshal([]byte(value), &services)
+	if err != nil {
+		return nil, InvalidEnvironmentVariableError{Name: VcapServicesEnvironmentVariable, Reason: err.Error()}
+	}
+	return services, nil
+}
+
+func (a VcapApplication) EnvironmentVariable() (*models.EnvironmentVariable, error) {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return &models.EnvironmentVariable{Name: VcapApplicationEnvironmentVariable, Value: string(payload)}, nil
+}
+
+func (s VcapServices) EnvironmentVariable() (*models.EnvironmentVariable, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return &models.EnvironmentVariable{Name: VcapServicesEnvironmentVariable, Value: string(payload)}, nil
+}
+
+func (d DesireAppRequestFromCC) VcapApplication() (*VcapApplication, error) {
+	return ParseVcapApplication(d.Environment)
+}
+
+func (d DesireAppRequestFromCC) VcapServices() (VcapServices, error) {
+	return ParseVcapServices(d.Environment)
+}
+
+func (t TaskRequestFromCC) VcapApplication() (*VcapApplication, error) {
+	return ParseVcapApplication(t.EnvironmentVariables)
+}
+
+func (t TaskRequestFromCC) VcapServices() (VcapServices, error) {
+	return ParseVcapServices(t.EnvironmentVariables)
+}
+
+// GenerateVcapApplication returns the VCAP_APPLICATION the launcher gives
+// instance of desiredApp: the one the CC sent, or an empty one, with the
+// instance's guid, index and container port and the app's limits filled in.
+// Like the recipe, it falls back to DefaultAppFileDescriptors when the CC
+// sends no file descriptor limit.
+func GenerateVcapApplication(desiredApp *DesireAppRequestFromCC, instance LRPInstance) (VcapApplication, error) {
+	app, err := desiredApp.VcapApplication()
+	if err != nil {
+		return VcapApplication{}, err
+	}
+	if app == nil {
+		app = &VcapApplication{}
+	}
+
+	fds := desiredApp.FileDescriptors
+	if fds == 0 {
+		fds = DefaultAppFileDescriptors
+	}
+
+	index := instance.Index
+	app.Host = vcapApplicationHost
+	app.InstanceID = instance.InstanceGuid
+	app.InstanceIndex = &index
+	app.Port = containerPort(desiredApp, instance)
+	app.Limits = &VcapApplicationLimits{
+		Disk: desiredApp.DiskMB,
+		FDs:  fds,
+		Mem:  desiredApp.MemoryMB,
+	}
+	return *app, nil
+}
+
+type instancePort struct {
+	External         uint32 `json:"external"`
+	Internal         uint32 `json:"internal"`
+	ExternalTLSProxy uint32 `json:"external_tls_proxy,omitempty"`
+	InternalTLSProxy uint32 `json:"internal_tls_proxy,omitempty"`
+}
+
+// GenerateInstanceEnvironment returns the environment instance of
+// desiredApp runs with: the app's environment merged with the CF_INSTANCE_*
+// variables, PORT, MEMORY_LIMIT and the generated VCAP_APPLICATION. It is
+// meant for fakes and simulators that need a realistic environment.
+func GenerateInstanceEnvironment(desiredApp *DesireAppRequestFromCC, instance LRPInstance) ([]*models.EnvironmentVariable, error) {
+	app, err := GenerateVcapApplication(desiredApp, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	vcapApplication, err := app.EnvironmentVariable()
+	if err != nil {
+		return nil, err
+	}
+
+	system := []*models.EnvironmentVariable{
+		{Name: "CF_INSTANCE_GUID", Value: instance.InstanceGuid},
+		{Name: "CF_INSTANCE_INDEX", Value: strconv.FormatUint(uint64(instance.Index), 10)},
+	}
+	if instance.Host != "" {
+		system = append(system, &models.EnvironmentVariable{Name: "CF_INSTANCE_IP", Value: instance.Host})
+	}
+	if instance.NetInfo.InstanceAddress != "" {
+		system = append(system, &models.EnvironmentVariable{Name: "CF_INSTANCE_INTERNAL_IP", Value: instance.NetInfo.InstanceAddress})
+	}
+
+	ports := make([]instancePort, 0, len(instance.NetInfo.Ports))
+	for _, mapping := range instance.NetInfo.Ports {
+		if mapping == nil {
+			continue
+		}
+		ports = append(ports, instancePort{
+			External:         mapping.HostPort,
+			Internal:         mapping.ContainerPort,
+			ExternalTLSProxy: mapping.HostTlsProxyPort,
+			InternalTLSProxy: mapping.ContainerTlsProxyPort,
+		})
+	}
+	if len(ports) > 0 && instance.Host != "" {
+		hostPort := strconv.FormatUint(uint64(ports[0].External), 10)
+		system = append(system,
+			&models.EnvironmentVariable{Name: "CF_INSTANCE_PORT", Value: hostPort},
+			&models.EnvironmentVariable{Name: "CF_INSTANCE_ADDR", Value: net.JoinHostPort(instance.Host, hostPort)},
+		)
+	}
+	portsPayload, err := json.Marshal(ports)
+	if err != nil {
+		return nil, err
+	}
+	system = append(system, &models.EnvironmentVariable{Name: "CF_INSTANCE_PORTS", Value: string(portsPayload)})
+
+	system = append(system, &models.EnvironmentVariable{Name: "PORT", Value: strconv.FormatUint(uint64(app.Port), 10)})
+	if desiredApp.MemoryMB > 0 {
+		system = append(system, &models.EnvironmentVariable{Name: "MEMORY_LIMIT", Value: strconv.Itoa(desiredApp.MemoryMB) + "m"})
+	}
+	system = append(system, vcapApplication)
+
+	return MergeEnvironment(nil, desiredApp.Environment, system)
+}
+
+// containerPort is the port the instance listens on: its first mapped
+// container port, or else the first port the app asks for, or else the
+// port the recipe defaults to.
+func containerPort(desiredApp *DesireAppRequestFromCC, instance LRPInstance) uint32 {
+	for _, mapping := range instance.NetInfo.Ports {
+		if mapping != nil {
+			return mapping.ContainerPort
+		}
+	}
+	if len(desiredApp.Ports) > 0 {
+		return desiredApp.Ports[0]
+	}
+	return DefaultAppPort
+}
+
+// lookupEnvironmentVariable returns the last value env sets for name, the
+// one MergeEnvironment keeps.
+func lookupEnvironmentVariable(env []*models.EnvironmentVariable, name string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if env[i] != nil && env[i].Name == name {
+			return env[i].Value, true
+		}
+	}
+	return "", false
+}